@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+const (
+	alertmanagerAlertsPath      = "/api/v2/alerts"
+	defaultAlertName            = "PowerAdminMonitorAlert"
+	defaultAlertResendInterval  = time.Minute
+	defaultAlertmanagerTimeout  = 10 * time.Second
+	alertmanagerQueueSize       = 100
+	alertEndsAtResendMultiplier = 4
+)
+
+var (
+	alertmanagerAlertsSentTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "poweradmin_alertmanager_alerts_sent_total",
+		Help: "Number of alerts sent to Alertmanager",
+	})
+	alertmanagerErrorsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "poweradmin_alertmanager_errors_total",
+		Help: "Number of failed requests to Alertmanager",
+	})
+)
+
+// AlertmanagerConfig forwards the monitors in an alerting status to Alertmanager
+type AlertmanagerConfig struct {
+	URLs           []string          `yaml:"urls"`
+	AlertName      string            `yaml:"alertname"`
+	Severities     []SeverityRule    `yaml:"severities"`
+	Labels         map[string]string `yaml:"labels"`
+	ResendInterval time.Duration     `yaml:"resend_interval"`
+	Timeout        time.Duration     `yaml:"timeout"`
+}
+
+// SeverityRule gives the severity of the monitors whose status maps to one of the values with the status mapping
+type SeverityRule struct {
+	Values   []float64 `yaml:"values"`
+	Severity string    `yaml:"severity"`
+}
+
+// UnmarshalYAML checks the URLs and the severity rules
+func (c *AlertmanagerConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain AlertmanagerConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	for _, alertmanagerURL := range c.URLs {
+		parsed, err := url.Parse(alertmanagerURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return fmt.Errorf("invalid Alertmanager url %q", alertmanagerURL)
+		}
+	}
+	for _, rule := range c.Severities {
+		if len(rule.Values) == 0 || rule.Severity == "" {
+			return errors.New("a severity rule must have a severity and values")
+		}
+	}
+	return nil
+}
+
+// validate checks the settings depending on each other, on the merged config as they can be set in different files
+func (c *AlertmanagerConfig) validate() error {
+	if len(c.URLs) > 0 && len(c.Severities) == 0 {
+		return errors.New("the Alertmanager severities are required to know which monitors are alerting")
+	}
+	return nil
+}
+
+// severity returns the severity of a monitor from its mapped status value, the monitor is not alerting when no rule matches
+func (c *AlertmanagerConfig) severity(value MonitoredValue, mapping StatusConfig) (string, bool) {
+	mapped, _ := mapping.mapStatus(value)
+	for _, rule := range c.Severities {
+		for _, ruleValue := range rule.Values {
+			if ruleValue == mapped {
+				return rule.Severity, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (c *AlertmanagerConfig) resendInterval() time.Duration {
+	if c.ResendInterval <= 0 {
+		return defaultAlertResendInterval
+	}
+	return c.ResendInterval
+}
+
+// amAlert an alert of the Alertmanager v2 API
+type amAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// alertBatch alerts to send to the Alertmanagers configured when they were raised
+type alertBatch struct {
+	urls   []string
+	client *http.Client
+	alerts []amAlert
+}
+
+// activeAlert an alert sent to Alertmanager which is not resolved yet
+type activeAlert struct {
+	alert    amAlert
+	severity string
+	lastSent time.Time
+}
+
+// AlertForwarder keeps the active alerts of each instance and sends them to Alertmanager in the background.
+// It outlives the collectors rebuilt on reload so that the alerts are resolved after a reload.
+type AlertForwarder struct {
+	mutex  sync.Mutex
+	config AlertmanagerConfig
+	client *http.Client
+	// active alerts by instance name and monitor key
+	active map[string]map[string]*activeAlert
+	queue  chan alertBatch
+}
+
+// NewAlertForwarder starts the forwarder, it sends nothing until URLs are configured
+func NewAlertForwarder() *AlertForwarder {
+	forwarder := &AlertForwarder{
+		client: &http.Client{},
+		active: make(map[string]map[string]*activeAlert),
+		queue:  make(chan alertBatch, alertmanagerQueueSize),
+	}
+	go forwarder.run()
+	return forwarder
+}
+
+// configure applies a new config with the names of the instances, the active alerts of the remaining instances are kept.
+// The alerts of the removed instances, or all of them when no URL is left, are resolved in the previous Alertmanagers.
+func (f *AlertForwarder) configure(config AlertmanagerConfig, instances []string, now time.Time) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	kept := make(map[string]bool, len(instances))
+	if len(config.URLs) > 0 {
+		for _, instance := range instances {
+			kept[instance] = true
+		}
+	}
+	resolved := make([]amAlert, 0)
+	for instance, alerts := range f.active {
+		if kept[instance] {
+			continue
+		}
+		for _, active := range alerts {
+			active.alert.EndsAt = now
+			resolved = append(resolved, active.alert)
+		}
+		delete(f.active, instance)
+	}
+	if len(resolved) > 0 {
+		f.enqueue(alertBatch{urls: f.config.URLs, client: f.client, alerts: resolved})
+	}
+	f.config = config
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultAlertmanagerTimeout
+	}
+	f.client = &http.Client{Timeout: timeout}
+}
+
+func (f *AlertForwarder) enabled() bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.config.URLs) > 0
+}
+
+// newAlert builds the alert of an alerting monitor
+func (f *AlertForwarder) newAlert(instance string, value MonitoredValue, severity string, now time.Time) amAlert {
+	alertName := f.config.AlertName
+	if alertName == "" {
+		alertName = defaultAlertName
+	}
+	labels := make(map[string]string, len(f.config.Labels)+6)
+	for name, labelValue := range f.config.Labels {
+		labels[name] = labelValue
+	}
+	labels["alertname"] = alertName
+	labels["severity"] = severity
+	labels["group_path"] = value.GroupPath
+	labels["server_name"] = value.ServerName
+	labels["monitor"] = value.MonitorTitle
+	if instance != "" {
+		labels[instanceLabel] = instance
+	}
+	return amAlert{Labels: labels, Annotations: alertAnnotations(value), StartsAt: now}
+}
+
+// alertAnnotations returns new annotations on each send, the sent alerts are encoded in the background
+func alertAnnotations(value MonitoredValue) map[string]string {
+	return map[string]string{
+		"summary":     fmt.Sprintf("%s on %s is %s", value.MonitorTitle, value.ServerName, value.MonitorStatus),
+		"description": value.MonitorErrText,
+		"status":      value.MonitorStatus,
+	}
+}
+
+// process updates the active alerts of the instance with a successful collection and returns the alerts to send:
+// the new alerts, the alerts to resend and the resolved ones, including the monitors no longer collected
+func (f *AlertForwarder) process(instance string, values []MonitoredValue, mapping StatusConfig, now time.Time) []amAlert {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	resendInterval := f.config.resendInterval()
+	endsAt := now.Add(alertEndsAtResendMultiplier * resendInterval)
+	previous := f.active[instance]
+	current := make(map[string]*activeAlert)
+	toSend := make([]amAlert, 0)
+	for _, value := range values {
+		key := monitorKey(value)
+		severity, alerting := f.config.severity(value, mapping)
+		if !alerting {
+			continue
+		}
+		active, exists := previous[key]
+		if exists && active.severity != severity {
+			// the severity is a label, the alert with the previous severity is resolved
+			active.alert.EndsAt = now
+			toSend = append(toSend, active.alert)
+			exists = false
+		}
+		if !exists {
+			active = &activeAlert{alert: f.newAlert(instance, value, severity, now), severity: severity}
+		}
+		delete(previous, key)
+		current[key] = active
+		if exists && now.Sub(active.lastSent) < resendInterval {
+			continue
+		}
+		active.alert.Annotations = alertAnnotations(value)
+		active.alert.EndsAt = endsAt
+		active.lastSent = now
+		toSend = append(toSend, active.alert)
+	}
+	for _, resolved := range previous {
+		resolved.alert.EndsAt = now
+		toSend = append(toSend, resolved.alert)
+	}
+	f.active[instance] = current
+	return toSend
+}
+
+// forward processes the collected values, mapped with the status mapping of the instance, and queues the alerts to send
+func (f *AlertForwarder) forward(instance string, values []MonitoredValue, mapping StatusConfig, now time.Time) {
+	if f == nil || !f.enabled() {
+		return
+	}
+	alerts := f.process(instance, values, mapping, now)
+	if len(alerts) == 0 {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.enqueue(alertBatch{urls: f.config.URLs, client: f.client, alerts: alerts})
+}
+
+// enqueue queues the alerts to send, they are dropped if the queue is full
+func (f *AlertForwarder) enqueue(batch alertBatch) {
+	select {
+	case f.queue <- batch:
+	default:
+		log.Warnf("The Alertmanager queue is full, %d alerts dropped", len(batch.alerts))
+		alertmanagerErrorsTotal.Inc()
+	}
+}
+
+func (f *AlertForwarder) run() {
+	for batch := range f.queue {
+		sendAlerts(batch)
+	}
+}
+
+// sendAlerts posts the alerts to all the Alertmanagers of the batch
+func sendAlerts(batch alertBatch) {
+	data, err := json.Marshal(batch.alerts)
+	if err != nil {
+		log.Errorf("Error encoding the alerts: %v", err)
+		return
+	}
+	for _, alertmanagerURL := range batch.urls {
+		if err := postAlerts(batch.client, strings.TrimRight(alertmanagerURL, "/")+alertmanagerAlertsPath, data); err != nil {
+			log.Errorf("Error sending %d alerts to Alertmanager %s: %v", len(batch.alerts), alertmanagerURL, err)
+			alertmanagerErrorsTotal.Inc()
+			continue
+		}
+		alertmanagerAlertsSentTotal.Add(float64(len(batch.alerts)))
+	}
+}
+
+func postAlerts(client *http.Client, alertsURL string, data []byte) error {
+	resp, err := client.Post(alertsURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Alertmanager returned status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// testSeverities alerts for the statuses mapped to 2 (critical) and 3 (warning)
+var testSeverities = []SeverityRule{{Values: []float64{2}, Severity: "critical"}, {Values: []float64{3}, Severity: "warning"}}
+
+// testAlertMapping maps the statuses with the PowerAdmin codes, ok being 1, alert 2 and error 3
+var testAlertMapping = StatusConfig{
+	Rules:          []StatusRule{{Prefix: "alert", Value: 2}},
+	UseStatusCodes: true,
+}
+
+func TestAlertmanagerConfig_Severity(t *testing.T) {
+	config := AlertmanagerConfig{Severities: testSeverities}
+	tests := []struct {
+		status       string
+		wantSeverity string
+		wantAlerting bool
+	}{
+		{"OK", "", false},
+		{"Alert - Red", "critical", true},
+		{"Error", "warning", true},
+		{"Disabled", "", false},
+		{"Unknown", "", false},
+	}
+	for _, test := range tests {
+		severity, alerting := config.severity(MonitoredValue{MonitorValue: test.status, MonitorStatus: test.status}, testAlertMapping)
+		if severity != test.wantSeverity || alerting != test.wantAlerting {
+			t.Errorf("Wrong severity for %s: got %v, %v, want %v, %v", test.status, severity, alerting, test.wantSeverity, test.wantAlerting)
+		}
+	}
+
+	config = AlertmanagerConfig{}
+	if err := yaml.UnmarshalStrict([]byte("severities:\n  - values: [0]\n    severity: info\n"), &config); err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	mapping := StatusConfig{Statuses: map[string]float64{"ok": 1}}
+	if severity, alerting := config.severity(MonitoredValue{MonitorValue: "Can't Run"}, mapping); severity != "info" || !alerting {
+		t.Errorf("Wrong severity for a status mapped to the default value: got %v, %v", severity, alerting)
+	}
+	if _, alerting := config.severity(MonitoredValue{MonitorValue: "OK"}, mapping); alerting {
+		t.Errorf("A status mapped to another value should not alert")
+	}
+}
+
+func TestAlertmanagerConfig_UnmarshalYAML_Errors(t *testing.T) {
+	for _, config := range []string{
+		"urls: [\"alertmanager:9093\"]",
+		"severities:\n  - severity: critical\n",
+		"severities:\n  - values: [2]\n",
+	} {
+		if err := yaml.UnmarshalStrict([]byte(config), &AlertmanagerConfig{}); err == nil {
+			t.Errorf("An error should be raised for %s", config)
+		}
+	}
+}
+
+func TestLoadConfig_AlertmanagerSplit(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.yml": "alertmanager:\n  urls: [\"http://alertmanager:9093\"]\n",
+		"b.yml": "alertmanager:\n  severities:\n    - values: [2]\n      severity: critical\n",
+	})
+	defer os.RemoveAll(dir)
+
+	config, err := loadConfig(dir)
+	if err != nil {
+		t.Fatalf("The URLs and severities of different files should be merged: got %v", err)
+	}
+	if len(config.Alertmanager.URLs) != 1 || len(config.Alertmanager.Severities) != 1 {
+		t.Errorf("Wrong Alertmanager config: got %+v", config.Alertmanager)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "b.yml")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfig(dir); err == nil {
+		t.Errorf("The URLs without severities should raise an error")
+	}
+}
+
+func TestAlertForwarder_Process(t *testing.T) {
+	forwarder := NewAlertForwarder()
+	forwarder.configure(AlertmanagerConfig{ResendInterval: time.Minute, Labels: map[string]string{"env": "prod"}, Severities: testSeverities}, []string{"prod"}, time.Now())
+	start := time.Unix(1000, 0)
+	ping := MonitoredValue{MonitorID: "1", MonitorTitle: "Ping", MonitorValue: "Alert", MonitorStatus: "Alert", ServerName: "FXH1", GroupPath: "Dev"}
+	disk := MonitoredValue{MonitorID: "2", MonitorTitle: "Disk", MonitorValue: "OK", MonitorStatus: "OK", ServerName: "FXH1", GroupPath: "Dev"}
+
+	alerts := forwarder.process("prod", []MonitoredValue{ping, disk}, testAlertMapping, start)
+	if len(alerts) != 1 {
+		t.Fatalf("Wrong number of alerts: got %v, want %v", len(alerts), 1)
+	}
+	wantLabels := map[string]string{"alertname": defaultAlertName, "severity": "critical", "monitor": "Ping", "server_name": "FXH1", "group_path": "Dev", instanceLabel: "prod", "env": "prod"}
+	for name, want := range wantLabels {
+		if alerts[0].Labels[name] != want {
+			t.Errorf("Wrong value for label %s: got %v, want %v", name, alerts[0].Labels[name], want)
+		}
+	}
+	if !alerts[0].StartsAt.Equal(start) || !alerts[0].EndsAt.Equal(start.Add(4*time.Minute)) {
+		t.Errorf("Wrong alert times: got %v - %v", alerts[0].StartsAt, alerts[0].EndsAt)
+	}
+
+	if alerts := forwarder.process("prod", []MonitoredValue{ping, disk}, testAlertMapping, start.Add(30*time.Second)); len(alerts) != 0 {
+		t.Errorf("The alert should not be resent before the resend interval: got %v", alerts)
+	}
+	alerts = forwarder.process("prod", []MonitoredValue{ping, disk}, testAlertMapping, start.Add(time.Minute))
+	if len(alerts) != 1 || !alerts[0].StartsAt.Equal(start) {
+		t.Errorf("The alert should be resent after the resend interval: got %v", alerts)
+	}
+
+	ping.MonitorValue, ping.MonitorStatus = "Error", "Error"
+	alerts = forwarder.process("prod", []MonitoredValue{ping, disk}, testAlertMapping, start.Add(90*time.Second))
+	if len(alerts) != 2 || alerts[0].Labels["severity"] != "critical" || !alerts[0].EndsAt.Equal(start.Add(90*time.Second)) || alerts[1].Labels["severity"] != "warning" {
+		t.Errorf("A severity change should resolve the alert and raise a new one: got %v", alerts)
+	}
+
+	ping.MonitorValue, ping.MonitorStatus = "OK", "OK"
+	alerts = forwarder.process("prod", []MonitoredValue{ping, disk}, testAlertMapping, start.Add(2*time.Minute))
+	if len(alerts) != 1 || !alerts[0].EndsAt.Equal(start.Add(2*time.Minute)) {
+		t.Errorf("A recovered monitor should resolve the alert: got %v", alerts)
+	}
+	if len(forwarder.active["prod"]) != 0 {
+		t.Errorf("No alert should be active: got %v", forwarder.active["prod"])
+	}
+}
+
+func TestAlertForwarder_Forward(t *testing.T) {
+	received := make(chan []amAlert, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v2/alerts" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		alerts := make([]amAlert, 0)
+		if err := json.NewDecoder(r.Body).Decode(&alerts); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- alerts
+	}))
+	defer ts.Close()
+
+	forwarder := NewAlertForwarder()
+	value := MonitoredValue{MonitorID: "1", MonitorTitle: "Ping", MonitorValue: "Alert", MonitorStatus: "Alert", MonitorErrText: "Timeout", ServerName: "FXH1"}
+	forwarder.forward("", []MonitoredValue{value}, testAlertMapping, time.Now())
+	if len(forwarder.active) != 0 {
+		t.Errorf("Nothing should be processed without Alertmanager urls")
+	}
+
+	forwarder.configure(AlertmanagerConfig{URLs: []string{ts.URL + "/"}, Severities: testSeverities}, []string{""}, time.Now())
+	sentBefore := readMetric(alertmanagerAlertsSentTotal).value
+	forwarder.forward("", []MonitoredValue{value}, testAlertMapping, time.Now())
+	select {
+	case alerts := <-received:
+		if len(alerts) != 1 || alerts[0].Labels["monitor"] != "Ping" || alerts[0].Annotations["description"] != "Timeout" {
+			t.Errorf("Wrong alerts received: got %+v", alerts)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No alert received by Alertmanager")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for readMetric(alertmanagerAlertsSentTotal).value != sentBefore+1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := readMetric(alertmanagerAlertsSentTotal).value; got != sentBefore+1 {
+		t.Errorf("Wrong number of sent alerts: got %v, want %v", got, sentBefore+1)
+	}
+}
+
+func TestAlertForwarder_Configure_Resolve(t *testing.T) {
+	received := make(chan []amAlert, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		alerts := make([]amAlert, 0)
+		_ = json.NewDecoder(r.Body).Decode(&alerts)
+		received <- alerts
+	}))
+	defer ts.Close()
+	receive := func() []amAlert {
+		select {
+		case alerts := <-received:
+			return alerts
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No alert received by Alertmanager")
+			return nil
+		}
+	}
+
+	forwarder := NewAlertForwarder()
+	config := AlertmanagerConfig{URLs: []string{ts.URL}, Severities: testSeverities}
+	forwarder.configure(config, []string{"prod", "dr"}, time.Now())
+	value := MonitoredValue{MonitorID: "1", MonitorTitle: "Ping", MonitorValue: "Alert", MonitorStatus: "Alert", ServerName: "FXH1"}
+	forwarder.forward("prod", []MonitoredValue{value}, testAlertMapping, time.Now())
+	forwarder.forward("dr", []MonitoredValue{value}, testAlertMapping, time.Now())
+	receive()
+	receive()
+
+	// the dr instance is removed by a reload
+	now := time.Now()
+	forwarder.configure(config, []string{"prod"}, now)
+	if alerts := receive(); len(alerts) != 1 || alerts[0].Labels[instanceLabel] != "dr" || !alerts[0].EndsAt.Equal(now) {
+		t.Errorf("The alert of the removed instance should be resolved: got %+v", alerts)
+	}
+	if _, found := forwarder.active["prod"]; !found {
+		t.Errorf("The alerts of the remaining instance should be kept")
+	}
+
+	// the URLs are removed by a reload, the alerts are resolved in the previous Alertmanager
+	forwarder.configure(AlertmanagerConfig{}, []string{"prod"}, now)
+	if alerts := receive(); len(alerts) != 1 || alerts[0].Labels[instanceLabel] != "prod" || !alerts[0].EndsAt.Equal(now) {
+		t.Errorf("The alerts should be resolved when the URLs are removed: got %+v", alerts)
+	}
+	if len(forwarder.active) != 0 {
+		t.Errorf("No alert should be active: got %v", forwarder.active)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/log"
+)
+
+const groupPathSeparator = "^"
+
+// apiFilters the query filters of the JSON API, an empty filter matching everything
+type apiFilters struct {
+	groupPath string
+	server    string
+	statuses  map[string]struct{}
+}
+
+func newAPIFilters(req *http.Request) apiFilters {
+	params := req.URL.Query()
+	filters := apiFilters{
+		groupPath: params.Get("group"),
+		server:    params.Get("server"),
+		statuses:  make(map[string]struct{}),
+	}
+	for _, status := range params["status"] {
+		filters.statuses[strings.ToLower(status)] = struct{}{}
+	}
+	return filters
+}
+
+// matchGroup tells if the group path is the filtered group or one of its sub groups
+func (f *apiFilters) matchGroup(groupPath string) bool {
+	return f.groupPath == "" || groupPath == f.groupPath || strings.HasPrefix(groupPath, f.groupPath+groupPathSeparator)
+}
+
+// matchServer tells if the server name or ID is the filtered server
+func (f *apiFilters) matchServer(name string, id string) bool {
+	return f.server == "" || f.server == name || f.server == id
+}
+
+func (f *apiFilters) matchStatus(status string) bool {
+	if len(f.statuses) == 0 {
+		return true
+	}
+	_, exists := f.statuses[strings.ToLower(status)]
+	return exists
+}
+
+// apiCollector returns the collector of the instance parameter, the first one by default
+func (r *Reloader) apiCollector(w http.ResponseWriter, req *http.Request) (*Collector, bool) {
+	collector, err := r.instanceCollector(req.URL.Query().Get("instance"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return collector, true
+}
+
+// APIMonitorsHandler serves the monitors collected for the metrics, the received statuses included, filtered by group, server and status
+func (r *Reloader) APIMonitorsHandler(w http.ResponseWriter, req *http.Request) {
+	collector, ok := r.apiCollector(w, req)
+	if !ok {
+		return
+	}
+	values, _, err := collector.monitoredValues(time.Now())
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	filters := newAPIFilters(req)
+	result := MonitoredValues{Values: make([]MonitoredValue, 0, len(values))}
+	for _, value := range values {
+		if filters.matchGroup(value.GroupPath) && filters.matchServer(value.ServerName, value.ServerID) && filters.matchStatus(value.MonitorStatus) {
+			result.Values = append(result.Values, value)
+		}
+	}
+	writeAPIResponse(w, result)
+}
+
+// APIServersHandler serves the servers of the configured groups, filtered by group, server and status
+func (r *Reloader) APIServersHandler(w http.ResponseWriter, req *http.Request) {
+	collector, ok := r.apiCollector(w, req)
+	if !ok {
+		return
+	}
+	client := collector.PowerAdminClient
+	groups, err := client.GetGroupList()
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	groupSet := make(map[string]Group, len(groups.Groups))
+	for _, group := range groups.Groups {
+		groupSet[group.Path] = group
+	}
+	filters := newAPIFilters(req)
+	result := ServerList{Servers: make([]Server, 0)}
+	for _, groupFilter := range collector.Config.Groups {
+		group, groupExists := groupSet[groupFilter.GroupPath]
+		if !groupExists || !filters.matchGroup(group.Path) {
+			continue
+		}
+		servers, err := client.GetServerList(group.ID)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		for _, server := range filterServers(servers.Servers, groupFilter.Servers) {
+			if filters.matchServer(server.Name, server.ID) && filters.matchStatus(server.Status) {
+				result.Servers = append(result.Servers, server)
+			}
+		}
+	}
+	writeAPIResponse(w, result)
+}
+
+// APIGroupsHandler serves the PowerAdmin groups, filtered by group
+func (r *Reloader) APIGroupsHandler(w http.ResponseWriter, req *http.Request) {
+	collector, ok := r.apiCollector(w, req)
+	if !ok {
+		return
+	}
+	groups, err := collector.PowerAdminClient.GetGroupList()
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	filters := newAPIFilters(req)
+	result := GroupList{Groups: make([]Group, 0, len(groups.Groups))}
+	for _, group := range groups.Groups {
+		if filters.matchGroup(group.Path) {
+			result.Groups = append(result.Groups, group)
+		}
+	}
+	writeAPIResponse(w, result)
+}
+
+func writeAPIError(w http.ResponseWriter, err error) {
+	log.Errorf("API request failed: %v", err)
+	http.Error(w, fmt.Sprintf("error querying PowerAdmin: %s", err), http.StatusBadGateway)
+}
+
+func writeAPIResponse(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Errorf("Error writing the API response: %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newAPITestReloader() *Reloader {
+	api := MockPAExternalAPI{}
+	api.On("GetResources", []GroupFilter{{GroupPath: "Servers^Dev"}, {GroupPath: "Servers^Prod"}}).Return(&MonitoredValues{Values: []MonitoredValue{
+		{MonitorTitle: "Ping", MonitorStatus: "OK", ServerID: "1", ServerName: "DEV1", GroupPath: "Servers^Dev"},
+		{MonitorTitle: "Disk", MonitorStatus: "Alert", ServerID: "1", ServerName: "DEV1", GroupPath: "Servers^Dev"},
+		{MonitorTitle: "Ping", MonitorStatus: "Alert", ServerID: "2", ServerName: "PROD1", GroupPath: "Servers^Prod"},
+	}}, nil)
+	api.On("GetGroupList").Return(&GroupList{Groups: []Group{
+		{ID: "10", Path: "Servers"},
+		{ID: "11", Path: "Servers^Dev", ParentID: "10"},
+		{ID: "12", Path: "Servers^Prod", ParentID: "10"},
+		{ID: "13", Path: "Servers^Development", ParentID: "10"},
+	}}, nil)
+	api.On("GetServerList", "11").Return(&ServerList{Servers: []Server{{ID: "1", Name: "DEV1", Status: "ok"}}}, nil)
+	api.On("GetServerList", "12").Return(&ServerList{Servers: []Server{{ID: "2", Name: "PROD1", Status: "alert"}, {ID: "3", Name: "PROD2", Status: "ok"}}}, nil)
+
+	failing := MockPAExternalAPI{}
+	failing.On("GetGroupList").Return((*GroupList)(nil), errors.New("PowerAdmin is down"))
+
+	groups := []GroupFilter{{GroupPath: "Servers^Dev"}, {GroupPath: "Servers^Prod"}}
+	return &Reloader{collectors: []*Collector{
+		NewCollector(&api, Config{Groups: groups, InstanceName: "prod"}),
+		NewCollector(&failing, Config{Groups: groups, InstanceName: "dr"}),
+	}}
+}
+
+func TestReloader_APIMonitorsHandler(t *testing.T) {
+	reloader := newAPITestReloader()
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", 3},
+		{"group=Servers^Dev", 2},
+		{"group=Servers", 3},
+		{"server=PROD1", 1},
+		{"server=1&status=alert", 1},
+		{"status=OK&status=Alert", 3},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		reloader.APIMonitorsHandler(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/monitors?"+test.query, nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Wrong status for %s: got %v, want %v", test.query, recorder.Code, http.StatusOK)
+		}
+		result := MonitoredValues{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Error decoding the response: %v", err)
+		}
+		if len(result.Values) != test.want {
+			t.Errorf("Wrong number of monitors for %s: got %v, want %v", test.query, len(result.Values), test.want)
+		}
+	}
+}
+
+func TestReloader_APIMonitorsHandler_Overlay(t *testing.T) {
+	reloader := newAPITestReloader()
+	overlay := NewStatusOverlay()
+	reloader.collectors[0].Overlay = overlay
+	ping := MonitoredValue{MonitorTitle: "Ping", ServerName: "DEV1"}
+	overlay.merge("prod", []MonitoredValue{ping}, 0, time.Now())
+	ping.MonitorStatus = "Alert"
+	ping.MonitorLastRun = time.Now()
+	overlay.set("prod", ping, time.Now())
+
+	recorder := httptest.NewRecorder()
+	reloader.APIMonitorsHandler(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/monitors?server=DEV1&status=alert", nil))
+	result := MonitoredValues{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Error decoding the response: %v", err)
+	}
+	if len(result.Values) != 2 {
+		t.Errorf("The received status should be served like in the metrics: got %+v", result.Values)
+	}
+}
+
+func TestReloader_APIServersHandler(t *testing.T) {
+	reloader := newAPITestReloader()
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", 3},
+		{"group=Servers^Prod", 2},
+		{"status=ok", 2},
+		{"server=PROD2", 1},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		reloader.APIServersHandler(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/servers?"+test.query, nil))
+		result := ServerList{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Error decoding the response: %v", err)
+		}
+		if len(result.Servers) != test.want {
+			t.Errorf("Wrong number of servers for %s: got %v, want %v", test.query, len(result.Servers), test.want)
+		}
+	}
+}
+
+func TestReloader_APIGroupsHandler(t *testing.T) {
+	reloader := newAPITestReloader()
+	tests := []struct {
+		query      string
+		wantStatus int
+		want       int
+	}{
+		{"", http.StatusOK, 4},
+		{"group=Servers^Dev", http.StatusOK, 1},
+		{"instance=dr", http.StatusBadGateway, 0},
+		{"instance=test", http.StatusBadRequest, 0},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		reloader.APIGroupsHandler(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/groups?"+test.query, nil))
+		if recorder.Code != test.wantStatus {
+			t.Errorf("Wrong status for %s: got %v, want %v", test.query, recorder.Code, test.wantStatus)
+			continue
+		}
+		if test.wantStatus != http.StatusOK {
+			continue
+		}
+		result := GroupList{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Error decoding the response: %v", err)
+		}
+		if len(result.Groups) != test.want {
+			t.Errorf("Wrong number of groups for %s: got %v, want %v", test.query, len(result.Groups), test.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// checkConfig validates the config folder and, with probe, checks the configured groups and servers against PowerAdmin.
+// It returns the exit code of the check-config command.
+func checkConfig(configDir string, probe bool, out io.Writer) int {
+	fmt.Fprintf(out, "Checking %s\n", configDir)
+	config, err := loadConfig(configDir)
+	if err != nil {
+		fmt.Fprintf(out, "  FAILED: %v\n", err)
+		return 1
+	}
+	exitCode := 0
+	for _, instanceConfig := range config.instanceConfigs() {
+		if instanceConfig.InstanceName != "" {
+			fmt.Fprintf(out, "Instance %s\n", instanceConfig.InstanceName)
+		}
+		if code := checkInstanceConfig(instanceConfig, probe, out); code != 0 {
+			exitCode = code
+		}
+	}
+	return exitCode
+}
+
+// checkInstanceConfig checks the config of one PowerAdmin instance
+func checkInstanceConfig(config Config, probe bool, out io.Writer) int {
+	client, err := newClientFromConfig(config)
+	if err != nil {
+		fmt.Fprintf(out, "  FAILED: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(out, "  SUCCESS: %d group filters found\n", len(config.Groups))
+	if !probe {
+		return 0
+	}
+
+	fmt.Fprintf(out, "Probing %s\n", config.ServerURL)
+	problems, err := probeConfig(client, config.Groups)
+	if err != nil {
+		fmt.Fprintf(out, "  FAILED: %v\n", err)
+		return 1
+	}
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Fprintf(out, "  FAILED: %s\n", problem)
+		}
+		return 1
+	}
+	fmt.Fprintln(out, "  SUCCESS: all the configured groups and servers exist")
+	return 0
+}
+
+// probeConfig returns the configured groups and servers which don't exist in PowerAdmin
+func probeConfig(client PAExternalAPI, groupFilters []GroupFilter) ([]string, error) {
+	groups, err := client.GetGroupList()
+	if err != nil {
+		return nil, err
+	}
+	groupSet := make(map[string]Group, len(groups.Groups))
+	for _, group := range groups.Groups {
+		groupSet[group.Path] = group
+	}
+	problems := make([]string, 0)
+	for _, filter := range groupFilters {
+		group, groupExists := groupSet[filter.GroupPath]
+		if !groupExists {
+			problems = append(problems, fmt.Sprintf("group %q not found", filter.GroupPath))
+			continue
+		}
+		if len(filter.Servers) == 0 {
+			continue
+		}
+		servers, err := client.GetServerList(group.ID)
+		if err != nil {
+			return nil, err
+		}
+		serverSet := make(map[string]struct{}, len(servers.Servers))
+		for _, server := range servers.Servers {
+			serverSet[server.Name] = struct{}{}
+		}
+		for _, name := range filter.Servers {
+			if _, serverExists := serverSet[name]; !serverExists {
+				problems = append(problems, fmt.Sprintf("server %q not found in group %q", name, filter.GroupPath))
+			}
+		}
+	}
+	return problems, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestProbeConfig(t *testing.T) {
+	api := MockPAExternalAPI{}
+	api.On("GetGroupList").Return(&GroupList{Groups: []Group{{ID: "193", Path: "Servers/Devices^Live^FX"}}}, nil)
+	api.On("GetServerList", "193").Return(&ServerList{Servers: []Server{{ID: "568", Name: "FXH1"}}}, nil)
+
+	problems, err := probeConfig(&api, []GroupFilter{
+		{GroupPath: "Servers/Devices^Live^FX", Servers: []string{"FXH1", "FXH9"}},
+		{GroupPath: "NOFX"},
+	})
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("Wrong number of problems: got %v, want %v", problems, 2)
+	}
+	if !strings.Contains(problems[0], "FXH9") {
+		t.Errorf("First problem should be about server FXH9: got %v", problems[0])
+	}
+	if !strings.Contains(problems[1], "NOFX") {
+		t.Errorf("Second problem should be about group NOFX: got %v", problems[1])
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	resourcesHandler := func(w http.ResponseWriter, r *http.Request) {
+		apiParam := r.URL.Query()["API"][0]
+		if apiParam == "GET_GROUP_LIST" {
+			_, _ = w.Write([]byte(groupListString))
+		} else if apiParam == "GET_SERVER_LIST" {
+			_, _ = w.Write([]byte(serverListString))
+		}
+	}
+	ts := httptest.NewServer(http.HandlerFunc(resourcesHandler))
+	defer ts.Close()
+
+	tests := []struct {
+		config string
+		probe  bool
+		want   int
+	}{
+		{"api_key: \"1234\"\ngroup:\n  - path: \"NOFX\"\n", false, 0},
+		{"api_key: \"1234\"\ngroup:\n  - path: \"Servers/Devices^Live^FX\"\n    servers: [\"FXH1\"]\n", true, 0},
+		{"api_key: \"1234\"\ngroup:\n  - path: \"NOFX\"\n", true, 1},
+		{"api_key: \"\"\n", false, 1},
+		{"api_key: \"1234\"\ngroups: []\n", false, 1},
+	}
+	for _, test := range tests {
+		dir := writeConfigFiles(t, map[string]string{
+			"config.yml": fmt.Sprintf("server: %q\n%s", ts.URL, test.config),
+		})
+		out := &bytes.Buffer{}
+		if got := checkConfig(dir, test.probe, out); got != test.want {
+			t.Errorf("Wrong exit code for %q: got %v, want %v, output: %s", test.config, got, test.want, out.String())
+		}
+		os.RemoveAll(dir)
+	}
+}
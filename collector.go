@@ -3,24 +3,51 @@ package main
 import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
+	"math"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 )
 
 var (
 	powerAdminErrorDesc = prometheus.NewDesc("poweradmin_error", "Error collecting metrics", nil, nil)
 	invalidMetricChars  = regexp.MustCompile("[^a-zA-Z0-9_:]")
+	unmappedStatusTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "poweradmin_unmapped_status_total",
+		Help: "Number of monitor statuses without a value in the status mapping",
+	}, []string{"status"})
 )
 
 // Collector generic collector type
 type Collector struct {
 	PowerAdminClient PAExternalAPI
 	Config           Config
+	// Tracker records the status changes when status tracking is enabled
+	Tracker *StatusTracker
+	// Alerts forwards the alerting monitors to Alertmanager when configured
+	Alerts *AlertForwarder
+	// Events receives the status changes when event sinks are configured
+	Events *EventDispatcher
+	// Overlay holds the statuses received by syslog since the last collection
+	Overlay *StatusOverlay
+	// warned is shared with the probes of the instance so that a status is only warned about once
+	warned *statusWarnings
+}
+
+// statusWarnings the statuses without mapping already logged
+type statusWarnings struct {
+	mutex    sync.Mutex
+	statuses map[string]struct{}
 }
 
 // NewCollector returns the collector
 func NewCollector(client PAExternalAPI, config Config) *Collector {
-	return &Collector{PowerAdminClient: client, Config: config}
+	return &Collector{
+		PowerAdminClient: client,
+		Config:           config,
+		warned:           &statusWarnings{statuses: make(map[string]struct{})},
+	}
 }
 
 // Describe to satisfy the collector interface.
@@ -30,24 +57,72 @@ func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 
 // Collect metrics from PowerAdmin external API
 func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	if err := c.collect(ch); err != nil {
+		ch <- prometheus.NewInvalidMetric(powerAdminErrorDesc, err)
+	}
+}
+
+// monitoredValues returns the monitors collected from PowerAdmin with the received statuses merged and the limits applied,
+// and the number of monitors dropped by the limits per reason
+func (c *Collector) monitoredValues(now time.Time) ([]MonitoredValue, map[string]int, error) {
 	metrics, err := c.PowerAdminClient.GetResources(c.Config.Groups)
 	if err != nil {
 		log.Infof("Failed to get metrics for groups: %v", err)
-		ch <- prometheus.NewInvalidMetric(powerAdminErrorDesc, err)
-		return
+		return nil, nil, err
 	}
 	log.Infof("Received %d metrics", len(metrics.Values))
-	for _, metric := range metrics.Values {
+	values := c.Overlay.merge(c.Config.InstanceName, metrics.Values, c.Config.Syslog.StatusTTL, now)
+	values, dropped := applyLimits(values, c.Config.Limits)
+	return values, dropped, nil
+}
+
+// collect sends the metrics of the monitors and returns the error raised getting them from PowerAdmin
+func (c *Collector) collect(ch chan<- prometheus.Metric) error {
+	now := time.Now()
+	values, dropped, err := c.monitoredValues(now)
+	if err != nil {
+		return err
+	}
+	for reason, count := range dropped {
+		log.Warnf("Dropped %d series because of the %s limit", count, reason)
+		seriesDroppedTotal.WithLabelValues(reason).Add(float64(count))
+	}
+	if c.Tracker != nil && (c.Config.StatusTracking.Enabled || c.Events != nil) {
+		c.Events.publish(c.Tracker.update(c.Config.InstanceName, values, now))
+		if c.Config.StatusTracking.Enabled {
+			c.Tracker.collect(c.Config.InstanceName, ch)
+		}
+	}
+	c.Alerts.forward(c.Config.InstanceName, values, c.Config.StatusMapping, now)
+	for _, metric := range values {
 		metricName := getFormattedMetricName(metric.MonitorTitle)
-		labels := make(map[string]string, 2)
+		metricHelp := metricName
+		labels := make(map[string]string, 4)
 		labels["group_path"] = metric.GroupPath
 		labels["server_name"] = metric.ServerName
-		ch <- prometheus.MustNewConstMetric(
-			prometheus.NewDesc(metricName, metricName, nil, labels),
+		if c.Config.InstanceName != "" {
+			labels[instanceLabel] = c.Config.InstanceName
+		}
+		if c.Config.MonitorTypes.Enabled {
+			monitorType := c.Config.MonitorTypes.classify(metric.MonitorTitle)
+			labels["monitor_type"] = monitorType
+			if c.Config.MonitorTypes.TypeFamilies && monitorType != otherMonitorType {
+				metricName = getMonitorTypeMetricName(monitorType)
+				metricHelp = "Status of the " + monitorType + " monitors"
+				labels["monitor"] = metric.MonitorTitle
+			}
+		}
+		sample := prometheus.MustNewConstMetric(
+			prometheus.NewDesc(metricName, metricHelp, nil, labels),
 			prometheus.UntypedValue,
-			getFloatValue(metric.MonitorValue, c.Config),
+			c.getFloatValue(metric),
 		)
+		if timestamp, ok := sampleTimestamp(metric.MonitorLastRun, now, c.Config.Timestamps); ok {
+			sample = prometheus.NewMetricWithTimestamp(timestamp, sample)
+		}
+		ch <- sample
 	}
+	return nil
 }
 
 func getFormattedMetricName(name string) string {
@@ -63,9 +138,26 @@ func getFormattedMetricName(name string) string {
 	return metricName
 }
 
-func getFloatValue(value string, config Config) float64 {
-	if st, stExist := config.StatusMapping.Statuses[strings.ToLower(value)]; stExist {
-		return st
+func (c *Collector) getFloatValue(metric MonitoredValue) float64 {
+	value, mapped := c.Config.StatusMapping.mapStatus(metric)
+	if mapped {
+		return value
+	}
+	unmappedStatusTotal.WithLabelValues(metric.MonitorValue).Inc()
+	c.warnUnmappedStatus(metric.MonitorValue)
+	if c.Config.StatusMapping.Strict {
+		return math.NaN()
+	}
+	return value
+}
+
+// warnUnmappedStatus logs a status without mapping only the first time it is seen
+func (c *Collector) warnUnmappedStatus(status string) {
+	c.warned.mutex.Lock()
+	defer c.warned.mutex.Unlock()
+	if _, warned := c.warned.statuses[status]; warned {
+		return
 	}
-	return config.StatusMapping.Default
+	c.warned.statuses[status] = struct{}{}
+	log.Warnf("The status %q has no value in the status mapping, check the statusMapping configuration", status)
 }
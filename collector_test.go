@@ -5,6 +5,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/mock"
+	"math"
 	"strings"
 	"testing"
 	"time"
@@ -159,3 +160,147 @@ func readMetric(m prometheus.Metric) MetricResult {
 	}
 	panic("Unsupported metric type")
 }
+
+func TestCollector_Collect_Limits(t *testing.T) {
+	api := MockPAExternalAPI{}
+	m := MonitoredValues{
+		Values: []MonitoredValue{
+			{MonitorTitle: "Toto", MonitorValue: "OK", ServerID: "158", GroupID: "154"},
+			{MonitorTitle: "Albert", MonitorValue: "OK", ServerID: "158", GroupID: "154"},
+		},
+	}
+	api.On("GetResources", mock.Anything).Return(&m, nil)
+	config := Config{
+		Groups: []GroupFilter{{GroupPath: "toto"}},
+		Limits: LimitsConfig{MaxSeries: 1},
+	}
+	droppedBefore := readCounter(seriesDroppedTotal, dropReasonSeries)
+	collector := NewCollector(&api, config)
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(ch)
+		close(ch)
+	}()
+	statusCount := 0
+	for range ch {
+		statusCount++
+	}
+	if statusCount != 1 {
+		t.Errorf("Wrong number of status metrics: got %v, want %v", statusCount, 1)
+	}
+	if dropped := readCounter(seriesDroppedTotal, dropReasonSeries) - droppedBefore; dropped != 1 {
+		t.Errorf("Wrong value for poweradmin_series_dropped_total: got %v, want %v", dropped, 1)
+	}
+}
+
+func TestCollector_Collect_UnmappedStatus(t *testing.T) {
+	api := MockPAExternalAPI{}
+	m := MonitoredValues{
+		Values: []MonitoredValue{
+			{MonitorTitle: "Toto", MonitorValue: "Unmapped Status", ServerID: "158", GroupID: "154"},
+		},
+	}
+	api.On("GetResources", mock.Anything).Return(&m, nil)
+	for _, strict := range []bool{false, true} {
+		config := Config{
+			Groups:        []GroupFilter{{GroupPath: "toto"}},
+			StatusMapping: StatusConfig{Default: 5, Strict: strict},
+		}
+		unmappedBefore := readCounter(unmappedStatusTotal, "Unmapped Status")
+		collector := NewCollector(&api, config)
+		ch := make(chan prometheus.Metric)
+		go func() {
+			collector.Collect(ch)
+			close(ch)
+		}()
+		for m := range ch {
+			got := readMetric(m)
+			if strict && !math.IsNaN(got.value) {
+				t.Errorf("Wrong value in strict mode: got %v, want NaN", got.value)
+			}
+			if !strict && got.value != 5 {
+				t.Errorf("Wrong value: got %v, want %v", got.value, 5)
+			}
+		}
+		if unmapped := readCounter(unmappedStatusTotal, "Unmapped Status") - unmappedBefore; unmapped != 1 {
+			t.Errorf("Wrong value for poweradmin_unmapped_status_total: got %v, want %v", unmapped, 1)
+		}
+	}
+}
+
+func TestCollector_Collect_Timestamps(t *testing.T) {
+	api := MockPAExternalAPI{}
+	lastRun := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	m := MonitoredValues{
+		Values: []MonitoredValue{
+			{MonitorTitle: "Toto", MonitorValue: "OK", MonitorLastRun: lastRun, ServerID: "158", GroupID: "154"},
+		},
+	}
+	api.On("GetResources", mock.Anything).Return(&m, nil)
+	config := Config{
+		Groups:     []GroupFilter{{GroupPath: "toto"}},
+		Timestamps: TimestampConfig{Enabled: true},
+	}
+	collector := NewCollector(&api, config)
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(ch)
+		close(ch)
+	}()
+	for m := range ch {
+		pb := &dto.Metric{}
+		m.Write(pb)
+		if pb.TimestampMs == nil {
+			t.Fatalf("Sample should have a timestamp")
+		}
+		if pb.GetTimestampMs() != lastRun.UnixNano()/int64(time.Millisecond) {
+			t.Errorf("Wrong timestamp: got %v, want %v", pb.GetTimestampMs(), lastRun.UnixNano()/int64(time.Millisecond))
+		}
+	}
+}
+
+func readCounter(counter *prometheus.CounterVec, labelValues ...string) float64 {
+	return readMetric(counter.WithLabelValues(labelValues...)).value
+}
+
+func TestCollector_Collect_MonitorTypes(t *testing.T) {
+	api := MockPAExternalAPI{}
+	m := MonitoredValues{
+		Values: []MonitoredValue{
+			{MonitorTitle: "Ping FXMACHINE1", MonitorValue: "OK", ServerName: "FXMACHINE1"},
+			{MonitorTitle: "Custom Check", MonitorValue: "OK", ServerName: "FXMACHINE1"},
+		},
+	}
+	api.On("GetResources", mock.Anything).Return(&m, nil)
+	config := Config{
+		Groups:       []GroupFilter{{GroupPath: "toto"}},
+		MonitorTypes: MonitorTypeConfig{Enabled: true, TypeFamilies: true},
+	}
+	collector := NewCollector(&api, config)
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(ch)
+		close(ch)
+	}()
+	names := make(map[string]labelMap)
+	for m := range ch {
+		names[metricName(m)] = readMetric(m).labels
+	}
+	if labels, exists := names["poweradmin_ping_monitor_status"]; !exists {
+		t.Errorf("Metric poweradmin_ping_monitor_status not collected: got %v", names)
+	} else if labels["monitor"] != "Ping FXMACHINE1" || labels["monitor_type"] != "ping" {
+		t.Errorf("Wrong labels for poweradmin_ping_monitor_status: got %v", labels)
+	}
+	if labels, exists := names["custom_check_status"]; !exists {
+		t.Errorf("Metric custom_check_status not collected: got %v", names)
+	} else if labels["monitor_type"] != otherMonitorType {
+		t.Errorf("Wrong monitor_type label for custom_check_status: got %v", labels["monitor_type"])
+	}
+}
+
+// metricName returns the fully qualified name of the metric from its description
+func metricName(m prometheus.Metric) string {
+	desc := m.Desc().String()
+	name := desc[strings.Index(desc, "\"")+1:]
+	return name[:strings.Index(name, "\"")]
+}
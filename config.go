@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+var envVarReference = regexp.MustCompile(`\$\{([a-zA-Z_][a-zA-Z0-9_]*)\}`)
+
+// isConfigFile tells if a file of the config folder has to be parsed
+func isConfigFile(name string) bool {
+	extension := strings.ToLower(filepath.Ext(name))
+	return extension == ".yml" || extension == ".yaml"
+}
+
+// mergeConfigTree merges the YAML tree parsed from a file into the tree of the files already parsed.
+// Maps are merged, lists are appended and a scalar defined with different values in two files is an error.
+// origins keeps the file defining each scalar to report conflicts.
+func mergeConfigTree(merged map[interface{}]interface{}, tree map[interface{}]interface{}, keyPath string, file string, origins map[string]string) error {
+	for key, value := range tree {
+		path := fmt.Sprintf("%v", key)
+		if keyPath != "" {
+			path = keyPath + "." + path
+		}
+		existing, exists := merged[key]
+		if !exists || existing == nil {
+			merged[key] = value
+			recordOrigins(value, path, file, origins)
+			continue
+		}
+		if value == nil {
+			continue
+		}
+		switch typedValue := value.(type) {
+		case map[interface{}]interface{}:
+			existingMap, isMap := existing.(map[interface{}]interface{})
+			if !isMap {
+				return fmt.Errorf("%s: %s is a map but is not in %s", file, path, origins[path])
+			}
+			if err := mergeConfigTree(existingMap, typedValue, path, file, origins); err != nil {
+				return err
+			}
+		case []interface{}:
+			existingList, isList := existing.([]interface{})
+			if !isList {
+				return fmt.Errorf("%s: %s is a list but is not in %s", file, path, origins[path])
+			}
+			merged[key] = append(existingList, typedValue...)
+		default:
+			if !reflect.DeepEqual(existing, value) {
+				return fmt.Errorf("%s: %s is already defined with a different value in %s", file, path, origins[path])
+			}
+		}
+	}
+	return nil
+}
+
+func recordOrigins(value interface{}, path string, file string, origins map[string]string) {
+	origins[path] = file
+	if valueMap, isMap := value.(map[interface{}]interface{}); isMap {
+		for key, child := range valueMap {
+			recordOrigins(child, fmt.Sprintf("%s.%v", path, key), file, origins)
+		}
+	}
+}
+
+// parseConfigFile parses a config file and replaces the environment variable references of its values
+func parseConfigFile(data []byte) (map[interface{}]interface{}, error) {
+	tree := make(map[interface{}]interface{})
+	if err := yaml.UnmarshalStrict(data, &tree); err != nil {
+		return nil, err
+	}
+	// with the references replaced by a number, only the unquoted references are parsed as numbers
+	var typing interface{}
+	if err := yaml.Unmarshal(envVarReference.ReplaceAll(data, []byte("0")), &typing); err != nil {
+		typing = nil
+	}
+	if _, err := expandEnvVars(tree, typing); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// expandEnvVars replaces the ${VAR} references in the string values of a parsed YAML tree with the value of the
+// environment variables, so that a value can't change the structure of the config. An unquoted value made of a single
+// reference takes the type of the variable value when it is a number or a boolean, typing being the tree parsed with
+// the references replaced by a number. Other uses of $ are kept as is so that regexes are not modified.
+func expandEnvVars(tree interface{}, typing interface{}) (interface{}, error) {
+	missing := make(map[string]struct{})
+	expanded := expandTreeEnvVars(tree, typing, missing)
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("environment variables not set: %s", strings.Join(names, ", "))
+	}
+	return expanded, nil
+}
+
+func expandTreeEnvVars(tree interface{}, typing interface{}, missing map[string]struct{}) interface{} {
+	switch typedTree := tree.(type) {
+	case map[interface{}]interface{}:
+		typingMap, _ := typing.(map[interface{}]interface{})
+		for key, value := range typedTree {
+			typedTree[key] = expandTreeEnvVars(value, typingMap[key], missing)
+		}
+	case []interface{}:
+		typingList, _ := typing.([]interface{})
+		for i, value := range typedTree {
+			var typingValue interface{}
+			if len(typingList) == len(typedTree) {
+				typingValue = typingList[i]
+			}
+			typedTree[i] = expandTreeEnvVars(value, typingValue, missing)
+		}
+	case string:
+		_, unquoted := typing.(int)
+		return expandStringEnvVars(typedTree, unquoted, missing)
+	}
+	return tree
+}
+
+func expandStringEnvVars(value string, unquoted bool, missing map[string]struct{}) interface{} {
+	expanded := envVarReference.ReplaceAllStringFunc(value, func(reference string) string {
+		name := envVarReference.FindStringSubmatch(reference)[1]
+		variable, exists := os.LookupEnv(name)
+		if !exists {
+			missing[name] = struct{}{}
+		}
+		return variable
+	})
+	if !unquoted || expanded == value || envVarReference.FindString(value) != value {
+		return expanded
+	}
+	// an unquoted single reference, keep the numbers and booleans typed but never a structure
+	var scalar interface{}
+	if err := yaml.Unmarshal([]byte(expanded), &scalar); err == nil {
+		switch scalar.(type) {
+		case int, int64, uint64, float64, bool:
+			return scalar
+		}
+	}
+	return expanded
+}
+
+// compile compiles the regexes and templates of the merged config, the ones of the instances and modules included
+func (c *Config) compile() error {
+	if err := c.StatusMapping.compile(); err != nil {
+		return fmt.Errorf("statusMapping: %v", err)
+	}
+	if err := c.MonitorTypes.compile(); err != nil {
+		return fmt.Errorf("monitor_types: %v", err)
+	}
+	for _, instance := range c.Instances {
+		if instance.StatusMapping == nil {
+			continue
+		}
+		if err := instance.StatusMapping.compile(); err != nil {
+			return fmt.Errorf("instance %s: statusMapping: %v", instance.Name, err)
+		}
+	}
+	for name, module := range c.Modules {
+		if module.StatusMapping != nil {
+			if err := module.StatusMapping.compile(); err != nil {
+				return fmt.Errorf("module %s: statusMapping: %v", name, err)
+			}
+		}
+		if module.MonitorTypes != nil {
+			if err := module.MonitorTypes.compile(); err != nil {
+				return fmt.Errorf("module %s: monitor_types: %v", name, err)
+			}
+		}
+	}
+	if err := c.ServiceDiscovery.compile(); err != nil {
+		return fmt.Errorf("service_discovery: %v", err)
+	}
+	if err := c.Syslog.compile(); err != nil {
+		return fmt.Errorf("syslog: %v", err)
+	}
+	return nil
+}
+
+// readAPIKeyFile sets the API key from the api_key_file, relative paths being relative to the config folder
+func (c *Config) readAPIKeyFile(configDir string) error {
+	apiKey, err := readAPIKey(configDir, c.APIKey, c.APIKeyFile)
+	if err != nil {
+		return err
+	}
+	c.APIKey = apiKey
+	return nil
+}
+
+// readAPIKey returns the API key read from apiKeyFile if set, apiKey otherwise
+func readAPIKey(configDir string, apiKey string, apiKeyFile string) (string, error) {
+	if apiKeyFile == "" {
+		return apiKey, nil
+	}
+	if apiKey != "" {
+		return "", errors.New("api_key and api_key_file cannot be both set")
+	}
+	key, err := ioutil.ReadFile(resolveConfigPath(configDir, apiKeyFile))
+	if err != nil {
+		return "", fmt.Errorf("error reading the API key file: %v", err)
+	}
+	return strings.TrimSpace(string(key)), nil
+}
+
+// resolveConfigPath returns the path of a file referenced in the config, relative paths being relative to the config folder
+func resolveConfigPath(configDir string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(configDir, path)
+}
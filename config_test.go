@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIsConfigFile(t *testing.T) {
+	tests := map[string]bool{
+		"config.yml":     true,
+		"config.YAML":    true,
+		"config.yml~":    false,
+		"config.yml.bak": false,
+		"README.md":      false,
+	}
+	for name, want := range tests {
+		if got := isConfigFile(name); got != want {
+			t.Errorf("Wrong result for %s: got %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestMergeConfigTree_TypeMismatch(t *testing.T) {
+	merged := make(map[interface{}]interface{})
+	origins := make(map[string]string)
+	err := mergeConfigTree(merged, map[interface{}]interface{}{"group": []interface{}{"a"}}, "", "a.yml", origins)
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	err = mergeConfigTree(merged, map[interface{}]interface{}{"group": map[interface{}]interface{}{"path": "b"}}, "", "b.yml", origins)
+	if err == nil || !strings.Contains(err.Error(), "b.yml") {
+		t.Errorf("Error should refer to b.yml: got %v", err)
+	}
+}
+
+func TestExpandEnvVars(t *testing.T) {
+	os.Setenv("POWERADMIN_EXPORTER_TEST_KEY", "secret")
+	os.Setenv("POWERADMIN_EXPORTER_TEST_PORT", "9575")
+	defer os.Unsetenv("POWERADMIN_EXPORTER_TEST_KEY")
+	defer os.Unsetenv("POWERADMIN_EXPORTER_TEST_PORT")
+
+	expanded, err := parseConfigFile([]byte("api_key: \"${POWERADMIN_EXPORTER_TEST_KEY}\"\nregex: \"^ok$\"\n" +
+		"ports:\n  - ${POWERADMIN_EXPORTER_TEST_PORT}\n  - \"${POWERADMIN_EXPORTER_TEST_PORT}\"\n  - \"${POWERADMIN_EXPORTER_TEST_KEY}-${POWERADMIN_EXPORTER_TEST_PORT}\"\n"))
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	want := map[interface{}]interface{}{"api_key": "secret", "regex": "^ok$", "ports": []interface{}{9575, "9575", "secret-9575"}}
+	if !reflect.DeepEqual(expanded, want) {
+		t.Errorf("Wrong expanded config: got %v, want %v", expanded, want)
+	}
+	if _, err := parseConfigFile([]byte("api_key: ${POWERADMIN_EXPORTER_TEST_MISSING}\n")); err == nil {
+		t.Errorf("A variable not set should raise an error")
+	}
+}
+
+func TestLoadConfig_EnvVars(t *testing.T) {
+	os.Setenv("POWERADMIN_EXPORTER_TEST_KEY", "a\"b: c\n# d\ngroup: [x]")
+	defer os.Unsetenv("POWERADMIN_EXPORTER_TEST_KEY")
+	dir := writeConfigFiles(t, map[string]string{
+		"config.yml": "# ${POWERADMIN_EXPORTER_TEST_COMMENT} is not set\napi_key: \"${POWERADMIN_EXPORTER_TEST_KEY}\"\n",
+	})
+	defer os.RemoveAll(dir)
+
+	config, err := loadConfig(dir)
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	if config.APIKey != "a\"b: c\n# d\ngroup: [x]" || len(config.Groups) != 0 {
+		t.Errorf("The variable value should be kept as a string: got %q and %v", config.APIKey, config.Groups)
+	}
+}
+
+func TestLoadConfig_EnvVarsNumericKey(t *testing.T) {
+	for _, key := range []string{"0123", "on", "0x1F", "007", "1e3"} {
+		os.Setenv("POWERADMIN_EXPORTER_TEST_KEY", key)
+		dir := writeConfigFiles(t, map[string]string{"config.yml": "api_key: \"${POWERADMIN_EXPORTER_TEST_KEY}\"\n"})
+		config, err := loadConfig(dir)
+		os.RemoveAll(dir)
+		if err != nil {
+			t.Fatalf("Error should be nil: got %v", err)
+		}
+		if config.APIKey != key {
+			t.Errorf("The quoted variable value should be kept as is: got %q, want %q", config.APIKey, key)
+		}
+	}
+	os.Unsetenv("POWERADMIN_EXPORTER_TEST_KEY")
+}
+
+func TestConfig_ReadAPIKeyFile(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{"api_key": "secret\n"})
+	defer os.RemoveAll(dir)
+
+	config := Config{APIKeyFile: "api_key"}
+	if err := config.readAPIKeyFile(dir); err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	if config.APIKey != "secret" {
+		t.Errorf("Wrong API key: got %q, want %q", config.APIKey, "secret")
+	}
+	config = Config{APIKey: "1234", APIKeyFile: "api_key"}
+	if err := config.readAPIKeyFile(dir); err == nil {
+		t.Errorf("Setting api_key and api_key_file should raise an error")
+	}
+	config = Config{APIKeyFile: filepath.Join(dir, "missing")}
+	if err := config.readAPIKeyFile(dir); err == nil {
+		t.Errorf("A missing API key file should raise an error")
+	}
+}
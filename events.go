@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+const (
+	eventSinkFile    = "file"
+	eventSinkWebhook = "webhook"
+	eventSinkStdout  = "stdout"
+
+	defaultEventQueueSize      = 1000
+	defaultWebhookTimeout      = 10 * time.Second
+	defaultWebhookMaxRetries   = 3
+	defaultWebhookRetryBackoff = time.Second
+	// shutdownEventsTimeout how long the queued events are delivered for when the process stops
+	shutdownEventsTimeout = 5 * time.Second
+)
+
+var (
+	eventsSentTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "poweradmin_events_sent_total",
+		Help: "Number of status change events delivered to the sink",
+	}, []string{"sink"})
+	eventSendErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "poweradmin_event_send_errors_total",
+		Help: "Number of status change events the sink failed to deliver",
+	}, []string{"sink"})
+	eventsDroppedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "poweradmin_events_dropped_total",
+		Help: "Number of status change events dropped because the event queue was full",
+	})
+)
+
+// StatusEvent a status change of a monitor
+type StatusEvent struct {
+	Time       time.Time `json:"time"`
+	Instance   string    `json:"instance,omitempty"`
+	GroupPath  string    `json:"groupPath"`
+	ServerName string    `json:"serverName"`
+	MonitorID  string    `json:"monitorID"`
+	Monitor    string    `json:"monitor"`
+	OldStatus  string    `json:"oldStatus"`
+	NewStatus  string    `json:"newStatus"`
+	LastRun    time.Time `json:"lastRun"`
+	ErrText    string    `json:"errText"`
+}
+
+// EventsConfig the sinks receiving the status change events
+type EventsConfig struct {
+	QueueSize int               `yaml:"queue_size"`
+	Sinks     []EventSinkConfig `yaml:"sinks"`
+}
+
+// EventSinkConfig a sink of the status change events
+type EventSinkConfig struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+	// Path of the JSON lines file of the file sink
+	Path string `yaml:"path"`
+	// URL, Headers, Timeout, MaxRetries and RetryBackoff of the webhook sink
+	URL          string            `yaml:"url"`
+	Headers      map[string]string `yaml:"headers"`
+	Timeout      time.Duration     `yaml:"timeout"`
+	MaxRetries   *int              `yaml:"max_retries"`
+	RetryBackoff time.Duration     `yaml:"retry_backoff"`
+}
+
+// UnmarshalYAML checks the type of the sink and the setting it needs
+func (c *EventSinkConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain EventSinkConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	switch c.Type {
+	case eventSinkFile:
+		if c.Path == "" {
+			return fmt.Errorf("the path of the %s event sink must be set", eventSinkFile)
+		}
+	case eventSinkWebhook:
+		webhookURL, err := url.Parse(c.URL)
+		if err != nil || (webhookURL.Scheme != "http" && webhookURL.Scheme != "https") {
+			return fmt.Errorf("invalid url %q for the %s event sink", c.URL, eventSinkWebhook)
+		}
+	case eventSinkStdout:
+	default:
+		return fmt.Errorf("invalid event sink type %q, must be %s, %s or %s", c.Type, eventSinkFile, eventSinkWebhook, eventSinkStdout)
+	}
+	return nil
+}
+
+func (c *EventSinkConfig) name() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Type
+}
+
+// eventSink delivers the events to one destination
+type eventSink interface {
+	send(event StatusEvent) error
+	close() error
+}
+
+// writerSink writes the events as JSON lines
+type writerSink struct {
+	writer io.Writer
+	closer io.Closer
+}
+
+func (s *writerSink) send(event StatusEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = s.writer.Write(append(data, '\n'))
+	return err
+}
+
+func (s *writerSink) close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// webhookSink posts the events as JSON, retrying the failed requests
+type webhookSink struct {
+	url          string
+	headers      map[string]string
+	client       *http.Client
+	maxRetries   int
+	retryBackoff time.Duration
+}
+
+func newWebhookSink(config EventSinkConfig) *webhookSink {
+	sink := &webhookSink{
+		url:          config.URL,
+		headers:      config.Headers,
+		client:       &http.Client{Timeout: config.Timeout},
+		maxRetries:   defaultWebhookMaxRetries,
+		retryBackoff: config.RetryBackoff,
+	}
+	if config.Timeout <= 0 {
+		sink.client.Timeout = defaultWebhookTimeout
+	}
+	if config.MaxRetries != nil {
+		sink.maxRetries = *config.MaxRetries
+	}
+	if config.RetryBackoff <= 0 {
+		sink.retryBackoff = defaultWebhookRetryBackoff
+	}
+	return sink
+}
+
+func (s *webhookSink) send(event StatusEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	backoff := s.retryBackoff
+	for attempt := 0; ; attempt++ {
+		err = s.post(data)
+		if err == nil || attempt >= s.maxRetries {
+			return err
+		}
+		log.Debugf("Webhook request failed, retrying in %s: %v", backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (s *webhookSink) close() error {
+	return nil
+}
+
+func (s *webhookSink) post(data []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range s.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned status %s", resp.Status)
+	}
+	return nil
+}
+
+// newEventSink creates the sink of the config
+func newEventSink(config EventSinkConfig) (eventSink, error) {
+	switch config.Type {
+	case eventSinkFile:
+		file, err := os.OpenFile(config.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("error opening the events file: %v", err)
+		}
+		return &writerSink{writer: file, closer: file}, nil
+	case eventSinkWebhook:
+		return newWebhookSink(config), nil
+	case eventSinkStdout:
+		return &writerSink{writer: os.Stdout}, nil
+	}
+	return nil, fmt.Errorf("invalid event sink type %q", config.Type)
+}
+
+// EventDispatcher delivers the events to the sinks in the background so that slow sinks don't slow down the scrapes
+type EventDispatcher struct {
+	sinks     []eventSink
+	sinkNames []string
+	queue     chan StatusEvent
+	done      chan struct{}
+	mutex     sync.RWMutex
+	closed    bool
+}
+
+// NewEventDispatcher creates the sinks and starts the delivery, it returns nil when no sink is configured
+func NewEventDispatcher(config EventsConfig) (*EventDispatcher, error) {
+	if len(config.Sinks) == 0 {
+		return nil, nil
+	}
+	queueSize := config.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultEventQueueSize
+	}
+	dispatcher := &EventDispatcher{
+		queue: make(chan StatusEvent, queueSize),
+		done:  make(chan struct{}),
+	}
+	for _, sinkConfig := range config.Sinks {
+		sink, err := newEventSink(sinkConfig)
+		if err != nil {
+			dispatcher.closeSinks()
+			return nil, err
+		}
+		dispatcher.sinks = append(dispatcher.sinks, sink)
+		dispatcher.sinkNames = append(dispatcher.sinkNames, sinkConfig.name())
+		eventsSentTotal.WithLabelValues(sinkConfig.name())
+		eventSendErrorsTotal.WithLabelValues(sinkConfig.name())
+	}
+	go dispatcher.run()
+	return dispatcher, nil
+}
+
+// publish queues the events, they are dropped when the queue is full or the dispatcher closed
+func (d *EventDispatcher) publish(events []StatusEvent) {
+	if d == nil {
+		return
+	}
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	if d.closed {
+		return
+	}
+	for _, event := range events {
+		select {
+		case d.queue <- event:
+		default:
+			eventsDroppedTotal.Inc()
+		}
+	}
+}
+
+func (d *EventDispatcher) run() {
+	defer close(d.done)
+	defer d.closeSinks()
+	for event := range d.queue {
+		for i, sink := range d.sinks {
+			if err := sink.send(event); err != nil {
+				log.Errorf("Error sending the status change event to the %s sink: %v", d.sinkNames[i], err)
+				eventSendErrorsTotal.WithLabelValues(d.sinkNames[i]).Inc()
+				continue
+			}
+			eventsSentTotal.WithLabelValues(d.sinkNames[i]).Inc()
+		}
+	}
+}
+
+// Close delivers the queued events and closes the sinks, it can be called more than once
+func (d *EventDispatcher) Close() {
+	if d == nil {
+		return
+	}
+	d.stop()
+	<-d.done
+}
+
+// CloseTimeout closes the dispatcher like Close but waits at most timeout, it returns false when the queued events were not all delivered
+func (d *EventDispatcher) CloseTimeout(timeout time.Duration) bool {
+	if d == nil {
+		return true
+	}
+	d.stop()
+	select {
+	case <-d.done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// stop closes the queue the first time it is called, the events published after are dropped
+func (d *EventDispatcher) stop() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+}
+
+func (d *EventDispatcher) closeSinks() {
+	for _, sink := range d.sinks {
+		if err := sink.close(); err != nil {
+			log.Errorf("Error closing the event sink: %v", err)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestEventSinkConfig_UnmarshalYAML_Errors(t *testing.T) {
+	for _, config := range []string{
+		"type: file",
+		"type: webhook\nurl: \"ftp://events\"",
+		"type: kafka",
+	} {
+		if err := yaml.UnmarshalStrict([]byte(config), &EventSinkConfig{}); err == nil {
+			t.Errorf("An error should be raised for %s", config)
+		}
+	}
+}
+
+func TestStatusTracker_Update_Events(t *testing.T) {
+	tracker := NewStatusTracker()
+	value := MonitoredValue{MonitorID: "8937", MonitorTitle: "Ping", MonitorStatus: "OK", ServerName: "FXH1", GroupPath: "Dev"}
+	if events := tracker.update("prod", []MonitoredValue{value}, time.Unix(1000, 0)); len(events) != 0 {
+		t.Errorf("A new monitor should not raise an event: got %v", events)
+	}
+	value.MonitorStatus = "Alert"
+	value.MonitorErrText = "Timeout"
+	events := tracker.update("prod", []MonitoredValue{value}, time.Unix(1060, 0))
+	if len(events) != 1 {
+		t.Fatalf("Wrong number of events: got %v, want %v", len(events), 1)
+	}
+	want := StatusEvent{
+		Time:       time.Unix(1060, 0),
+		Instance:   "prod",
+		GroupPath:  "Dev",
+		ServerName: "FXH1",
+		MonitorID:  "8937",
+		Monitor:    "Ping",
+		OldStatus:  "ok",
+		NewStatus:  "alert",
+		ErrText:    "Timeout",
+	}
+	if events[0] != want {
+		t.Errorf("Wrong event: got %+v, want %+v", events[0], want)
+	}
+}
+
+func TestEventDispatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatalf("Error creating the folder: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var mutex sync.Mutex
+	attempts := 0
+	received := make([]StatusEvent, 0)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		event := StatusEvent{}
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received = append(received, event)
+	}))
+	defer ts.Close()
+
+	fileName := filepath.Join(dir, "events.jsonl")
+	dispatcher, err := NewEventDispatcher(EventsConfig{Sinks: []EventSinkConfig{
+		{Type: eventSinkFile, Path: fileName},
+		{Type: eventSinkWebhook, URL: ts.URL, Headers: map[string]string{"Authorization": "Bearer token"}, RetryBackoff: time.Millisecond},
+	}})
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	dispatcher.publish([]StatusEvent{
+		{Monitor: "Ping", OldStatus: "ok", NewStatus: "alert"},
+		{Monitor: "Disk", OldStatus: "alert", NewStatus: "ok"},
+	})
+	dispatcher.Close()
+	dispatcher.publish([]StatusEvent{{Monitor: "Ping"}})
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		t.Fatalf("Error opening the events file: %v", err)
+	}
+	defer file.Close()
+	lines := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		event := StatusEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Errorf("Invalid JSON line %s: %v", scanner.Text(), err)
+		}
+		lines++
+	}
+	if lines != 2 {
+		t.Errorf("Wrong number of events in the file: got %v, want %v", lines, 2)
+	}
+	if len(received) != 2 || received[0].Monitor != "Ping" || received[1].Monitor != "Disk" {
+		t.Errorf("Wrong events received by the webhook: got %+v", received)
+	}
+	if attempts != 3 {
+		t.Errorf("The failed webhook request should be retried: got %v attempts, want %v", attempts, 3)
+	}
+}
+
+func TestEventDispatcher_Close(t *testing.T) {
+	unblock := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer ts.Close()
+	defer close(unblock)
+
+	dispatcher, err := NewEventDispatcher(EventsConfig{Sinks: []EventSinkConfig{{Type: eventSinkWebhook, URL: ts.URL}}})
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	dispatcher.publish([]StatusEvent{{Monitor: "Ping"}})
+	if dispatcher.CloseTimeout(10 * time.Millisecond) {
+		t.Errorf("The event blocked in the webhook should not be delivered in time")
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dispatcher.CloseTimeout(time.Millisecond)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestNewEventDispatcher_NoSinks(t *testing.T) {
+	dispatcher, err := NewEventDispatcher(EventsConfig{})
+	if err != nil || dispatcher != nil {
+		t.Errorf("No dispatcher should be created without sinks: got %v, %v", dispatcher, err)
+	}
+	dispatcher.publish([]StatusEvent{{Monitor: "Ping"}})
+	dispatcher.Close()
+}
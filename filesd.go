@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/log"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	fileSDFormatJSON           = "json"
+	fileSDFormatYAML           = "yaml"
+	defaultFileSDRefreshPeriod = 5 * time.Minute
+	// fileSDFilePrefix starts the names of the files written by the exporter so that the files of other tools are never removed
+	fileSDFilePrefix = "poweradmin_"
+)
+
+var (
+	invalidFileNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+	// fileSDFileName matches the names of the files written by fileName, in both formats
+	fileSDFileName = regexp.MustCompile(`^` + fileSDFilePrefix + `[a-zA-Z0-9_-]*_[0-9a-f]{8}\.(json|yml)$`)
+)
+
+// FileSDConfig writes the discovered targets to Prometheus file_sd files, one file per group
+type FileSDConfig struct {
+	Directory       string        `yaml:"directory"`
+	Format          string        `yaml:"format"`
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+}
+
+// UnmarshalYAML checks the format
+func (c *FileSDConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain FileSDConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	switch c.Format {
+	case "", fileSDFormatJSON, fileSDFormatYAML:
+	default:
+		return fmt.Errorf("invalid file_sd format %q, must be %s or %s", c.Format, fileSDFormatJSON, fileSDFormatYAML)
+	}
+	return nil
+}
+
+func (c *FileSDConfig) refreshInterval() time.Duration {
+	if c.RefreshInterval <= 0 {
+		return defaultFileSDRefreshPeriod
+	}
+	return c.RefreshInterval
+}
+
+// fileName returns the name of the file of a group, prefixed with poweradmin_ and the instance name with instances.
+// It ends with a hash of the instance name and group path, as different paths can have the same name once cleaned.
+func (c *FileSDConfig) fileName(instanceName string, groupPath string) string {
+	name := strings.Trim(invalidFileNameChars.ReplaceAllString(strings.ToLower(groupPath), "_"), "_")
+	if instanceName != "" {
+		name = invalidFileNameChars.ReplaceAllString(instanceName, "_") + "_" + name
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(instanceName + "\x00" + groupPath))
+	name = fmt.Sprintf("%s%s_%08x", fileSDFilePrefix, name, hash.Sum32())
+	if c.Format == fileSDFormatYAML {
+		return name + ".yml"
+	}
+	return name + ".json"
+}
+
+// marshal encodes the target groups in the file format
+func (c *FileSDConfig) marshal(targetGroups []TargetGroup) ([]byte, error) {
+	if c.Format == fileSDFormatYAML {
+		return yaml.Marshal(targetGroups)
+	}
+	data, err := json.MarshalIndent(targetGroups, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// writeFileSD writes the files of the groups of the config and returns their names, the files which didn't change are not rewritten
+func writeFileSD(client PAExternalAPI, config Config) ([]string, error) {
+	fileSD := config.ServiceDiscovery.FileSD
+	discovered, err := discoverGroupTargets(client, config)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(discovered))
+	for _, group := range discovered {
+		data, err := fileSD.marshal(group.TargetGroups)
+		if err != nil {
+			return nil, err
+		}
+		name := fileSD.fileName(config.InstanceName, group.GroupPath)
+		names = append(names, name)
+		fileName := filepath.Join(fileSD.Directory, name)
+		if current, err := ioutil.ReadFile(fileName); err == nil && bytes.Equal(current, data) {
+			log.Debugf("%s is up to date", fileName)
+			continue
+		}
+		if err := writeFileAtomic(fileName, data); err != nil {
+			return nil, err
+		}
+		log.Infof("Wrote %d targets to %s", len(group.TargetGroups), fileName)
+	}
+	return names, nil
+}
+
+// removeStaleFileSD removes the files written for groups or instances no longer configured, the files not named by fileName are left
+func removeStaleFileSD(directory string, written map[string]bool) error {
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() || written[file.Name()] || !fileSDFileName.MatchString(file.Name()) {
+			continue
+		}
+		fileName := filepath.Join(directory, file.Name())
+		if err := os.Remove(fileName); err != nil {
+			return err
+		}
+		log.Infof("Removed the stale file %s", fileName)
+	}
+	return nil
+}
+
+// writeFileAtomic writes to a temporary file renamed to the file so that Prometheus never reads a partial file
+func writeFileAtomic(fileName string, data []byte) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(fileName), "."+filepath.Base(fileName))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), fileName)
+}
+
+// WriteFileSD writes the file_sd files of all the instances having a file_sd directory, an instance failing doesn't stop the others.
+// The stale files of a directory are removed when all its instances were written.
+func (r *Reloader) WriteFileSD() error {
+	var firstErr error
+	written := make(map[string]map[string]bool)
+	failed := make(map[string]bool)
+	for _, collector := range r.Collectors() {
+		directory := collector.Config.ServiceDiscovery.FileSD.Directory
+		if directory == "" {
+			continue
+		}
+		if written[directory] == nil {
+			written[directory] = make(map[string]bool)
+		}
+		names, err := writeFileSD(collector.PowerAdminClient, collector.Config)
+		if err != nil {
+			if collector.Config.InstanceName != "" {
+				err = fmt.Errorf("instance %s: %v", collector.Config.InstanceName, err)
+			}
+			log.Errorf("Error writing the file_sd files: %v", err)
+			failed[directory] = true
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		for _, name := range names {
+			written[directory][name] = true
+		}
+	}
+	for directory, names := range written {
+		if failed[directory] {
+			continue
+		}
+		if err := removeStaleFileSD(directory, names); err != nil {
+			log.Errorf("Error removing the stale file_sd files: %v", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// RunFileSD writes the file_sd files periodically and after each successful reload, the interval being read from the current config.
+// It returns at once when no file_sd directory is configured at startup, enabling file_sd needs a restart.
+func (r *Reloader) RunFileSD() {
+	enabled := false
+	for _, collector := range r.Collectors() {
+		enabled = enabled || collector.Config.ServiceDiscovery.FileSD.Directory != ""
+	}
+	if !enabled {
+		return
+	}
+	for {
+		_ = r.WriteFileSD()
+		fileSD := r.Config().ServiceDiscovery.FileSD
+		select {
+		case <-time.After(fileSD.refreshInterval()):
+		case <-r.fileSDRefresh:
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestFileSDConfig_FileName(t *testing.T) {
+	tests := []struct {
+		config    FileSDConfig
+		instance  string
+		groupPath string
+		want      string
+	}{
+		{FileSDConfig{}, "", "Servers/Devices^Live^FX", "poweradmin_servers_devices_live_fx_9909c295.json"},
+		{FileSDConfig{Format: fileSDFormatYAML}, "", "Dev", "poweradmin_dev_e960652e.yml"},
+		{FileSDConfig{}, "prod", "Servers/Devices^Live^FX Hosting", "poweradmin_prod_servers_devices_live_fx_hosting_ae1f3ad6.json"},
+		{FileSDConfig{}, "", "Servers/Dev", "poweradmin_servers_dev_6bcefe25.json"},
+		{FileSDConfig{}, "", "servers_dev", "poweradmin_servers_dev_9f537ef5.json"},
+	}
+	for _, test := range tests {
+		if got := test.config.fileName(test.instance, test.groupPath); got != test.want {
+			t.Errorf("Wrong file name for %s: got %v, want %v", test.groupPath, got, test.want)
+		}
+	}
+}
+
+func TestFileSDConfig_UnmarshalYAML_InvalidFormat(t *testing.T) {
+	if err := yaml.UnmarshalStrict([]byte("format: xml"), &FileSDConfig{}); err == nil {
+		t.Errorf("An invalid format should raise an error")
+	}
+}
+
+func TestWriteFileSD(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filesd")
+	if err != nil {
+		t.Fatalf("Error creating the folder: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	api := MockPAExternalAPI{}
+	api.On("GetGroupList").Return(&GroupList{Groups: []Group{{ID: "193", Path: "Servers/Devices^Live^FX"}}}, nil)
+	api.On("GetServerList", "193").Return(&ServerList{Servers: []Server{{ID: "568", Name: "FXH1"}, {ID: "709", Name: "FXH2"}}}, nil)
+	config := Config{
+		Groups: []GroupFilter{{GroupPath: "Servers/Devices^Live^FX", Servers: []string{"FXH1"}}, {GroupPath: "NOFX"}},
+		ServiceDiscovery: ServiceDiscoveryConfig{
+			Port:   9182,
+			FileSD: FileSDConfig{Directory: dir},
+		},
+	}
+	names, err := writeFileSD(&api, config)
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	if len(names) != 2 {
+		t.Errorf("Wrong written files: got %v", names)
+	}
+
+	fileName := filepath.Join(dir, "poweradmin_servers_devices_live_fx_9909c295.json")
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("Error reading the file_sd file: %v", err)
+	}
+	targetGroups := make([]TargetGroup, 0)
+	if err := json.Unmarshal(data, &targetGroups); err != nil {
+		t.Fatalf("Error decoding the file_sd file: %v", err)
+	}
+	if len(targetGroups) != 1 || targetGroups[0].Targets[0] != "FXH1:9182" {
+		t.Errorf("Wrong targets: got %+v", targetGroups)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "poweradmin_nofx_b717a83a.json")); err != nil || string(data) != "[]\n" {
+		t.Errorf("A missing group should have an empty file: got %q, %v", data, err)
+	}
+
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(fileName, past, past); err != nil {
+		t.Fatalf("Error changing the file time: %v", err)
+	}
+	if _, err := writeFileSD(&api, config); err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatalf("Error reading the file info: %v", err)
+	}
+	if !info.ModTime().Equal(past) {
+		t.Errorf("An unchanged file should not be rewritten")
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 2 {
+		t.Errorf("No temporary file should be left: got %d files", len(files))
+	}
+}
+
+func TestReloader_WriteFileSD_Stale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filesd")
+	if err != nil {
+		t.Fatalf("Error creating the folder: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"poweradmin_old_group_0123abcd.json", "poweradmin_old_group_0123abcd.yml", "targets.json",
+		"notes_0123abcd.txt", "windows_20240101.json", "node_exporter_deadbeef.yml"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("[]\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	api := MockPAExternalAPI{}
+	api.On("GetGroupList").Return(&GroupList{Groups: []Group{{ID: "1", Path: "Dev"}}}, nil)
+	api.On("GetServerList", "1").Return(&ServerList{Servers: []Server{{ID: "2", Name: "FXH1"}}}, nil)
+	failing := MockPAExternalAPI{}
+	failing.On("GetGroupList").Return((*GroupList)(nil), errors.New("PowerAdmin is down"))
+	fileSD := ServiceDiscoveryConfig{FileSD: FileSDConfig{Directory: dir}}
+	reloader := &Reloader{collectors: []*Collector{
+		NewCollector(&api, Config{InstanceName: "prod", Groups: []GroupFilter{{GroupPath: "Dev"}}, ServiceDiscovery: fileSD}),
+		NewCollector(&failing, Config{InstanceName: "dr", Groups: []GroupFilter{{GroupPath: "Dev"}}, ServiceDiscovery: fileSD}),
+	}}
+
+	if err := reloader.WriteFileSD(); err == nil {
+		t.Errorf("The failing instance should return an error")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "poweradmin_old_group_0123abcd.json")); err != nil {
+		t.Errorf("No file should be removed when an instance of the folder failed: %v", err)
+	}
+
+	reloader.collectors = reloader.collectors[:1]
+	if err := reloader.WriteFileSD(); err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	want := []string{"node_exporter_deadbeef.yml", "notes_0123abcd.txt", "poweradmin_prod_dev_d6499a33.json", "targets.json", "windows_20240101.json"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("Wrong files after removing the stale ones: got %v, want %v", names, want)
+	}
+}
+
+func TestReloader_Reload_FileSDRefresh(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"config.yml": "server: \"https://paserver\"\napi_key: \"1234\"\ngroup:\n  - path: \"Dev\"\n",
+	})
+	defer os.RemoveAll(dir)
+	reloader, err := NewReloader(dir)
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	select {
+	case <-reloader.fileSDRefresh:
+		t.Errorf("The first load should not ask for a refresh")
+	default:
+	}
+	if err := reloader.Reload(); err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	select {
+	case <-reloader.fileSDRefresh:
+	default:
+		t.Errorf("A successful reload should ask for a file_sd refresh")
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// groupNode a group of the group tree with its children
+type groupNode struct {
+	Group
+	Children []*groupNode `json:"children,omitempty"`
+}
+
+// runInspect runs one of the inspection commands with the client of the instance built from the config and returns the exit code.
+// The first instance is used when instance is empty.
+func runInspect(configDir string, instance string, errOut io.Writer, inspect func(client PAExternalAPI) error) int {
+	topConfig, err := loadConfig(configDir)
+	if err != nil {
+		fmt.Fprintf(errOut, "Error loading the config: %v\n", err)
+		return 1
+	}
+	config, err := topConfig.instanceConfig(instance)
+	if err != nil {
+		fmt.Fprintf(errOut, "Error: %v\n", err)
+		return 1
+	}
+	client, err := newClientFromConfig(config)
+	if err != nil {
+		fmt.Fprintf(errOut, "Error creating the PowerAdmin client: %v\n", err)
+		return 1
+	}
+	if err := inspect(client); err != nil {
+		fmt.Fprintf(errOut, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// buildGroupTree returns the root groups with their children, sorted by name
+func buildGroupTree(groups []Group) []*groupNode {
+	nodes := make(map[string]*groupNode, len(groups))
+	for _, group := range groups {
+		nodes[group.ID] = &groupNode{Group: group}
+	}
+	roots := make([]*groupNode, 0)
+	for _, group := range groups {
+		node := nodes[group.ID]
+		if parent, parentExists := nodes[group.ParentID]; parentExists && parent != node {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	sortGroupNodes(roots)
+	return roots
+}
+
+func sortGroupNodes(nodes []*groupNode) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	for _, node := range nodes {
+		sortGroupNodes(node.Children)
+	}
+}
+
+// printGroups prints the group tree
+func printGroups(client PAExternalAPI, output string, out io.Writer) error {
+	groups, err := client.GetGroupList()
+	if err != nil {
+		return err
+	}
+	tree := buildGroupTree(groups.Groups)
+	if output == outputJSON {
+		return printJSON(tree, out)
+	}
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tGROUP\tPATH")
+	printGroupNodes(tree, 0, writer)
+	return writer.Flush()
+}
+
+func printGroupNodes(nodes []*groupNode, depth int, writer io.Writer) {
+	for _, node := range nodes {
+		fmt.Fprintf(writer, "%s\t%s%s\t%s\n", node.ID, strings.Repeat("  ", depth), node.Name, node.Path)
+		printGroupNodes(node.Children, depth+1, writer)
+	}
+}
+
+// findGroup returns the group with the given path
+func findGroup(client PAExternalAPI, groupPath string) (Group, error) {
+	groups, err := client.GetGroupList()
+	if err != nil {
+		return Group{}, err
+	}
+	for _, group := range groups.Groups {
+		if group.Path == groupPath {
+			return group, nil
+		}
+	}
+	return Group{}, fmt.Errorf("group %q not found", groupPath)
+}
+
+// printServers prints the servers of a group
+func printServers(client PAExternalAPI, groupPath string, output string, out io.Writer) error {
+	group, err := findGroup(client, groupPath)
+	if err != nil {
+		return err
+	}
+	servers, err := client.GetServerList(group.ID)
+	if err != nil {
+		return err
+	}
+	if output == outputJSON {
+		return printJSON(servers.Servers, out)
+	}
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tNAME\tALIAS\tSTATUS")
+	for _, server := range servers.Servers {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", server.ID, server.Name, server.Alias, server.Status)
+	}
+	return writer.Flush()
+}
+
+// findServer returns the server with the given name or ID, in the given group or in all the groups when groupPath is empty
+func findServer(client PAExternalAPI, groupPath string, nameOrID string) (Server, error) {
+	groups := make([]Group, 0)
+	if groupPath != "" {
+		group, err := findGroup(client, groupPath)
+		if err != nil {
+			return Server{}, err
+		}
+		groups = append(groups, group)
+	} else {
+		groupList, err := client.GetGroupList()
+		if err != nil {
+			return Server{}, err
+		}
+		groups = groupList.Groups
+	}
+	for _, group := range groups {
+		servers, err := client.GetServerList(group.ID)
+		if err != nil {
+			return Server{}, err
+		}
+		for _, server := range servers.Servers {
+			if server.Name == nameOrID || server.ID == nameOrID {
+				return server, nil
+			}
+		}
+	}
+	return Server{}, fmt.Errorf("server %q not found", nameOrID)
+}
+
+// printMonitors prints the monitors of a server
+func printMonitors(client PAExternalAPI, groupPath string, serverName string, output string, out io.Writer) error {
+	server, err := findServer(client, groupPath, serverName)
+	if err != nil {
+		return err
+	}
+	monitors, err := client.GetMonitorInfos(server.ID)
+	if err != nil {
+		return err
+	}
+	if output == outputJSON {
+		return printJSON(monitors.Infos, out)
+	}
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tTITLE\tSTATUS\tLAST RUN")
+	for _, monitor := range monitors.Infos {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", monitor.ID, monitor.Title, monitor.Status, monitor.LastRun.Format("2006-01-02 15:04:05"))
+	}
+	return writer.Flush()
+}
+
+func printJSON(value interface{}, out io.Writer) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func inspectMockAPI() *MockPAExternalAPI {
+	api := &MockPAExternalAPI{}
+	api.On("GetGroupList").Return(&GroupList{Groups: []Group{
+		{ID: "0", Name: "Servers/Devices", Path: "Servers/Devices", ParentID: "-1"},
+		{ID: "647", Name: "Live", Path: "Servers/Devices^Live", ParentID: "0"},
+		{ID: "193", Name: "FX", Path: "Servers/Devices^Live^FX", ParentID: "647"},
+	}}, nil)
+	api.On("GetServerList", "0").Return(&ServerList{}, nil)
+	api.On("GetServerList", "647").Return(&ServerList{}, nil)
+	api.On("GetServerList", "193").Return(&ServerList{Servers: []Server{{ID: "568", Name: "FXH1", Alias: "FXH1", Status: "ok"}}}, nil)
+	api.On("GetMonitorInfos", "568").Return(&MonitorInfos{Infos: []MonitorInfo{{ID: "8937", Title: "Ping FXMACHINE1", Status: "OK"}}}, nil)
+	return api
+}
+
+func TestPrintGroups(t *testing.T) {
+	out := &bytes.Buffer{}
+	if err := printGroups(inspectMockAPI(), outputTable, out); err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	if !strings.Contains(out.String(), "    FX") {
+		t.Errorf("Group FX should be indented twice in the tree: got %s", out.String())
+	}
+
+	out.Reset()
+	if err := printGroups(inspectMockAPI(), outputJSON, out); err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	tree := make([]groupNode, 0)
+	if err := json.Unmarshal(out.Bytes(), &tree); err != nil {
+		t.Fatalf("Output should be JSON: got %v", err)
+	}
+	if len(tree) != 1 || tree[0].Children[0].Children[0].Path != "Servers/Devices^Live^FX" {
+		t.Errorf("Wrong group tree: got %s", out.String())
+	}
+}
+
+func TestPrintServers(t *testing.T) {
+	out := &bytes.Buffer{}
+	if err := printServers(inspectMockAPI(), "Servers/Devices^Live^FX", outputTable, out); err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	if !strings.Contains(out.String(), "FXH1") {
+		t.Errorf("Output should contain server FXH1: got %s", out.String())
+	}
+	if err := printServers(inspectMockAPI(), "NOFX", outputTable, out); err == nil {
+		t.Errorf("An unknown group should raise an error")
+	}
+}
+
+func TestPrintMonitors(t *testing.T) {
+	out := &bytes.Buffer{}
+	if err := printMonitors(inspectMockAPI(), "", "FXH1", outputJSON, out); err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	monitors := make([]MonitorInfo, 0)
+	if err := json.Unmarshal(out.Bytes(), &monitors); err != nil {
+		t.Fatalf("Output should be JSON: got %v", err)
+	}
+	if len(monitors) != 1 || monitors[0].Title != "Ping FXMACHINE1" {
+		t.Errorf("Wrong monitors: got %s", out.String())
+	}
+	if err := printMonitors(inspectMockAPI(), "Servers/Devices^Live^FX", "FXH9", outputTable, out); err == nil {
+		t.Errorf("An unknown server should raise an error")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+const instanceLabel = "poweradmin_instance"
+
+// InstanceConfig a PowerAdmin server monitored by the exporter, the settings not set are taken from the top level config
+type InstanceConfig struct {
+	Name          string        `yaml:"name"`
+	ServerURL     string        `yaml:"server"`
+	APIKey        string        `yaml:"api_key"`
+	APIKeyFile    string        `yaml:"api_key_file"`
+	APIKeyMethod  string        `yaml:"api_key_method"`
+	SkipTLSVerify *bool         `yaml:"skip_tls_verify"`
+	TLSConfig     *TLSConfig    `yaml:"tls_config"`
+	Proxy         *ProxyConfig  `yaml:"proxy"`
+	Groups        []GroupFilter `yaml:"group"`
+	StatusMapping *StatusConfig `yaml:"statusMapping"`
+	Timezone      string        `yaml:"timezone"`
+}
+
+// loadFiles reads the API key file and resolves the TLS file paths of the instance
+func (i *InstanceConfig) loadFiles(configDir string) error {
+	apiKey, err := readAPIKey(configDir, i.APIKey, i.APIKeyFile)
+	if err != nil {
+		return fmt.Errorf("instance %s: %v", i.Name, err)
+	}
+	i.APIKey = apiKey
+	if i.TLSConfig != nil {
+		i.TLSConfig.resolvePaths(configDir)
+	}
+	return nil
+}
+
+// validateInstances checks that the instances have a unique name
+func validateInstances(instances []InstanceConfig) error {
+	names := make(map[string]struct{}, len(instances))
+	for _, instance := range instances {
+		if instance.Name == "" {
+			return errors.New("all the instances must have a name")
+		}
+		if _, exists := names[instance.Name]; exists {
+			return fmt.Errorf("instance %s is defined more than once", instance.Name)
+		}
+		names[instance.Name] = struct{}{}
+	}
+	return nil
+}
+
+// instanceConfigs returns the config of each PowerAdmin instance, or the config itself when no instance is configured
+func (c *Config) instanceConfigs() []Config {
+	if len(c.Instances) == 0 {
+		return []Config{*c}
+	}
+	configs := make([]Config, 0, len(c.Instances))
+	for _, instance := range c.Instances {
+		config := *c
+		config.Instances = nil
+		config.InstanceName = instance.Name
+		if instance.ServerURL != "" {
+			config.ServerURL = instance.ServerURL
+		}
+		if instance.APIKey != "" {
+			config.APIKey = instance.APIKey
+			config.APIKeyFile = ""
+		}
+		if instance.APIKeyMethod != "" {
+			config.APIKeyMethod = instance.APIKeyMethod
+		}
+		if instance.SkipTLSVerify != nil {
+			config.SkipTLSVerify = *instance.SkipTLSVerify
+		}
+		if instance.TLSConfig != nil {
+			config.TLSConfig = *instance.TLSConfig
+		}
+		if instance.Proxy != nil {
+			config.Proxy = *instance.Proxy
+		}
+		if instance.Groups != nil {
+			config.Groups = instance.Groups
+		}
+		if instance.StatusMapping != nil {
+			config.StatusMapping = *instance.StatusMapping
+		}
+		if instance.Timezone != "" {
+			config.Timezone = instance.Timezone
+		}
+		configs = append(configs, config)
+	}
+	return configs
+}
+
+// instanceConfig returns the config of the named instance, the first one when name is empty
+func (c *Config) instanceConfig(name string) (Config, error) {
+	configs := c.instanceConfigs()
+	if name == "" {
+		return configs[0], nil
+	}
+	for _, config := range configs {
+		if config.InstanceName == name {
+			return config, nil
+		}
+	}
+	return Config{}, fmt.Errorf("instance %q not found", name)
+}
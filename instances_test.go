@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestValidateInstances(t *testing.T) {
+	tests := []struct {
+		instances []InstanceConfig
+		wantError bool
+	}{
+		{nil, false},
+		{[]InstanceConfig{{Name: "prod"}, {Name: "dr"}}, false},
+		{[]InstanceConfig{{Name: "prod"}, {}}, true},
+		{[]InstanceConfig{{Name: "prod"}, {Name: "prod"}}, true},
+	}
+	for _, test := range tests {
+		if err := validateInstances(test.instances); (err != nil) != test.wantError {
+			t.Errorf("Wrong error for %v: got %v", test.instances, err)
+		}
+	}
+}
+
+func TestConfig_InstanceConfigs(t *testing.T) {
+	skipTLSVerify := true
+	config := Config{
+		ServerURL: "https://prod",
+		APIKey:    "1234",
+		Groups:    []GroupFilter{{GroupPath: "Prod"}},
+		Instances: []InstanceConfig{
+			{Name: "prod"},
+			{Name: "dr", ServerURL: "https://dr", APIKey: "5678", SkipTLSVerify: &skipTLSVerify, Groups: []GroupFilter{{GroupPath: "DR"}}},
+		},
+	}
+	configs := config.instanceConfigs()
+	if len(configs) != 2 {
+		t.Fatalf("Wrong number of instance configs: got %v, want %v", len(configs), 2)
+	}
+	if configs[0].InstanceName != "prod" || configs[0].ServerURL != "https://prod" || configs[0].APIKey != "1234" || configs[0].Groups[0].GroupPath != "Prod" {
+		t.Errorf("The first instance should inherit the top level settings: got %+v", configs[0])
+	}
+	if configs[1].InstanceName != "dr" || configs[1].ServerURL != "https://dr" || configs[1].APIKey != "5678" || !configs[1].SkipTLSVerify || configs[1].Groups[0].GroupPath != "DR" {
+		t.Errorf("The second instance should override the top level settings: got %+v", configs[1])
+	}
+	if configs[0].Instances != nil || configs[1].Instances != nil {
+		t.Errorf("The instance configs should not have instances")
+	}
+
+	config, err := config.instanceConfig("dr")
+	if err != nil || config.ServerURL != "https://dr" {
+		t.Errorf("Wrong config for instance dr: got %+v, %v", config, err)
+	}
+	if _, err := config.instanceConfig("test"); err == nil {
+		t.Errorf("An unknown instance should raise an error")
+	}
+}
+
+func TestConfig_InstanceConfigs_NoInstances(t *testing.T) {
+	config := Config{ServerURL: "https://prod", APIKey: "1234"}
+	configs := config.instanceConfigs()
+	if len(configs) != 1 || configs[0].InstanceName != "" || configs[0].ServerURL != "https://prod" {
+		t.Errorf("The config itself should be returned without instances: got %+v", configs)
+	}
+}
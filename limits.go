@@ -0,0 +1,100 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sort"
+)
+
+const (
+	dropReasonMonitorsPerServer = "max_monitors_per_server"
+	dropReasonTitles            = "max_titles"
+	dropReasonSeries            = "max_series"
+)
+
+// seriesDroppedTotal is global rather than owned by a collector: the collectors are
+// rebuilt on each reload and one exists per instance, which would reset and duplicate it
+var seriesDroppedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "poweradmin_series_dropped_total",
+	Help: "Number of series dropped because of the configured limits",
+}, []string{"reason"})
+
+// LimitsConfig cardinality guardrails applied on each scrape, 0 means no limit
+type LimitsConfig struct {
+	MaxSeries            int `yaml:"max_series"`
+	MaxMonitorsPerServer int `yaml:"max_monitors_per_server"`
+	MaxTitles            int `yaml:"max_titles"`
+}
+
+// applyLimits returns the values kept by the limits and the number of values dropped per reason.
+// Values are sorted first so that the same values are always dropped for the same input.
+func applyLimits(values []MonitoredValue, limits LimitsConfig) ([]MonitoredValue, map[string]int) {
+	dropped := make(map[string]int)
+	if limits.MaxSeries <= 0 && limits.MaxMonitorsPerServer <= 0 && limits.MaxTitles <= 0 {
+		return values, dropped
+	}
+	sorted := make([]MonitoredValue, len(values))
+	copy(sorted, values)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.GroupPath != b.GroupPath {
+			return a.GroupPath < b.GroupPath
+		}
+		if a.ServerName != b.ServerName {
+			return a.ServerName < b.ServerName
+		}
+		if a.MonitorTitle != b.MonitorTitle {
+			return a.MonitorTitle < b.MonitorTitle
+		}
+		return a.MonitorID < b.MonitorID
+	})
+
+	allowedTitles := allowedTitleSet(sorted, limits.MaxTitles)
+	monitorsPerServer := make(map[string]int)
+	kept := make([]MonitoredValue, 0, len(sorted))
+	for _, value := range sorted {
+		if allowedTitles != nil {
+			if _, allowed := allowedTitles[value.MonitorTitle]; !allowed {
+				dropped[dropReasonTitles]++
+				continue
+			}
+		}
+		if limits.MaxMonitorsPerServer > 0 {
+			serverKey := value.GroupPath + "\xff" + value.ServerID
+			if monitorsPerServer[serverKey] >= limits.MaxMonitorsPerServer {
+				dropped[dropReasonMonitorsPerServer]++
+				continue
+			}
+			monitorsPerServer[serverKey]++
+		}
+		if limits.MaxSeries > 0 && len(kept) >= limits.MaxSeries {
+			dropped[dropReasonSeries]++
+			continue
+		}
+		kept = append(kept, value)
+	}
+	return kept, dropped
+}
+
+// allowedTitleSet returns the first maxTitles distinct titles in alphabetical order, nil when there is no limit
+func allowedTitleSet(values []MonitoredValue, maxTitles int) map[string]struct{} {
+	if maxTitles <= 0 {
+		return nil
+	}
+	titles := make([]string, 0)
+	seen := make(map[string]struct{})
+	for _, value := range values {
+		if _, exists := seen[value.MonitorTitle]; !exists {
+			seen[value.MonitorTitle] = struct{}{}
+			titles = append(titles, value.MonitorTitle)
+		}
+	}
+	sort.Strings(titles)
+	if len(titles) > maxTitles {
+		titles = titles[:maxTitles]
+	}
+	allowed := make(map[string]struct{}, len(titles))
+	for _, title := range titles {
+		allowed[title] = struct{}{}
+	}
+	return allowed
+}
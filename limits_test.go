@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+)
+
+func limitsTestValues() []MonitoredValue {
+	return []MonitoredValue{
+		{MonitorID: "4", MonitorTitle: "Ping B", ServerID: "2", ServerName: "Server2", GroupPath: "Dev"},
+		{MonitorID: "1", MonitorTitle: "Ping A", ServerID: "1", ServerName: "Server1", GroupPath: "Dev"},
+		{MonitorID: "3", MonitorTitle: "Disk C:", ServerID: "2", ServerName: "Server2", GroupPath: "Dev"},
+		{MonitorID: "2", MonitorTitle: "Disk C:", ServerID: "1", ServerName: "Server1", GroupPath: "Dev"},
+	}
+}
+
+func TestApplyLimits_NoLimits(t *testing.T) {
+	values := limitsTestValues()
+	kept, dropped := applyLimits(values, LimitsConfig{})
+	if len(kept) != len(values) {
+		t.Errorf("Wrong size for kept values: got %v, want %v", len(kept), len(values))
+	}
+	if kept[0].MonitorID != "4" {
+		t.Errorf("Values order should not change without limits: got %v, want %v", kept[0].MonitorID, "4")
+	}
+	if len(dropped) != 0 {
+		t.Errorf("No value should be dropped: got %v", dropped)
+	}
+}
+
+func TestApplyLimits_MaxSeries(t *testing.T) {
+	kept, dropped := applyLimits(limitsTestValues(), LimitsConfig{MaxSeries: 3})
+	if len(kept) != 3 {
+		t.Errorf("Wrong size for kept values: got %v, want %v", len(kept), 3)
+	}
+	if dropped[dropReasonSeries] != 1 {
+		t.Errorf("Wrong number of dropped series: got %v, want %v", dropped[dropReasonSeries], 1)
+	}
+	// the last value in group, server and title order is the one dropped
+	for _, value := range kept {
+		if value.MonitorID == "4" {
+			t.Errorf("Monitor 4 should have been dropped")
+		}
+	}
+}
+
+func TestApplyLimits_MaxMonitorsPerServer(t *testing.T) {
+	kept, dropped := applyLimits(limitsTestValues(), LimitsConfig{MaxMonitorsPerServer: 1})
+	if len(kept) != 2 {
+		t.Errorf("Wrong size for kept values: got %v, want %v", len(kept), 2)
+	}
+	if dropped[dropReasonMonitorsPerServer] != 2 {
+		t.Errorf("Wrong number of dropped series: got %v, want %v", dropped[dropReasonMonitorsPerServer], 2)
+	}
+	if kept[0].MonitorID != "2" || kept[1].MonitorID != "3" {
+		t.Errorf("Wrong monitors kept: got %v and %v, want %v and %v", kept[0].MonitorID, kept[1].MonitorID, "2", "3")
+	}
+}
+
+func TestApplyLimits_MaxTitles(t *testing.T) {
+	kept, dropped := applyLimits(limitsTestValues(), LimitsConfig{MaxTitles: 2})
+	if len(kept) != 3 {
+		t.Errorf("Wrong size for kept values: got %v, want %v", len(kept), 3)
+	}
+	if dropped[dropReasonTitles] != 1 {
+		t.Errorf("Wrong number of dropped series: got %v, want %v", dropped[dropReasonTitles], 1)
+	}
+	for _, value := range kept {
+		if value.MonitorTitle == "Ping B" {
+			t.Errorf("Title %s should have been dropped", value.MonitorTitle)
+		}
+	}
+}
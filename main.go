@@ -6,6 +6,8 @@ import (
 	"github.com/prometheus/common/log"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/version"
@@ -14,18 +16,53 @@ import (
 )
 
 var (
-	configPath    = kingpin.Flag("config.dir", "Exporter configuration folder.").Default("config").String()
-	listenAddress = kingpin.Flag("web.listen-address", "The address to listen on for HTTP requests.").Default(":9575").String()
-	metricsPath   = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
+	configPath      = kingpin.Flag("config.dir", "Exporter configuration folder.").Default("config").String()
+	listenAddress   = kingpin.Flag("web.listen-address", "The address to listen on for HTTP requests.").Default(":9575").String()
+	metricsPath     = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
+	webConfigFile   = kingpin.Flag("web.config.file", "Path to a configuration file that can enable TLS or authentication.").Default("").String()
+	enableLifecycle = kingpin.Flag("web.enable-lifecycle", "Enable the reload of the configuration via HTTP request.").Bool()
+	instance        = kingpin.Flag("instance", "Name of the PowerAdmin instance inspected by the groups, servers and monitors commands, the first one by default.").String()
+
+	_                  = kingpin.Command("serve", "Run the exporter.").Default()
+	checkConfigCommand = kingpin.Command("check-config", "Check the configuration and exit.")
+	checkConfigProbe   = checkConfigCommand.Flag("probe", "Check that the configured groups and servers exist in PowerAdmin.").Bool()
+	groupsCommand      = kingpin.Command("groups", "Print the PowerAdmin group tree.")
+	groupsOutput       = groupsCommand.Flag("output", "Output format.").Short('o').Default(outputTable).Enum(outputTable, outputJSON)
+	serversCommand     = kingpin.Command("servers", "Print the servers of a PowerAdmin group.")
+	serversGroup       = serversCommand.Flag("group", "Path of the group.").Required().String()
+	serversOutput      = serversCommand.Flag("output", "Output format.").Short('o').Default(outputTable).Enum(outputTable, outputJSON)
+	monitorsCommand    = kingpin.Command("monitors", "Print the monitors of a PowerAdmin server.")
+	monitorsServer     = monitorsCommand.Flag("server", "Name or ID of the server.").Required().String()
+	monitorsGroup      = monitorsCommand.Flag("group", "Path of the group of the server, all the groups are searched if not set.").String()
+	monitorsOutput     = monitorsCommand.Flag("output", "Output format.").Short('o').Default(outputTable).Enum(outputTable, outputJSON)
 )
 
 // Config collection of config files
 type Config struct {
-	ServerURL     string        `yaml:"server"`
-	APIKey        string        `yaml:"api_key"`
-	SkipTLSVerify bool          `yaml:"skip_tls_verify"`
-	Groups        []GroupFilter `yaml:"group"`
-	StatusMapping StatusConfig  `yaml:"statusMapping"`
+	ServerURL        string                  `yaml:"server"`
+	APIKey           string                  `yaml:"api_key"`
+	APIKeyFile       string                  `yaml:"api_key_file"`
+	APIKeyMethod     string                  `yaml:"api_key_method"`
+	SkipTLSVerify    bool                    `yaml:"skip_tls_verify"`
+	TLSConfig        TLSConfig               `yaml:"tls_config"`
+	Proxy            ProxyConfig             `yaml:"proxy"`
+	Timezone         string                  `yaml:"timezone"`
+	Groups           []GroupFilter           `yaml:"group"`
+	StatusMapping    StatusConfig            `yaml:"statusMapping"`
+	Limits           LimitsConfig            `yaml:"limits"`
+	Timestamps       TimestampConfig         `yaml:"timestamps"`
+	MonitorTypes     MonitorTypeConfig       `yaml:"monitor_types"`
+	Instances        []InstanceConfig        `yaml:"instances"`
+	Modules          map[string]ModuleConfig `yaml:"modules"`
+	ServiceDiscovery ServiceDiscoveryConfig  `yaml:"service_discovery"`
+	StatusTracking   StatusTrackingConfig    `yaml:"status_tracking"`
+	Events           EventsConfig            `yaml:"events"`
+	Alertmanager     AlertmanagerConfig      `yaml:"alertmanager"`
+	Webhook          WebhookConfig           `yaml:"webhook"`
+	Syslog           SyslogConfig            `yaml:"syslog"`
+	Push             PushConfig              `yaml:"push"`
+	// InstanceName name of the instance for the configs returned by instanceConfigs
+	InstanceName string `yaml:"-"`
 }
 
 // GroupFilter group selection
@@ -36,40 +73,87 @@ type GroupFilter struct {
 
 // StatusConfig configure the status values to be sent
 type StatusConfig struct {
-	Statuses map[string]float64 `yaml:"values"`
-	Default  float64            `yaml:"default"`
+	Statuses       map[string]float64 `yaml:"values"`
+	Default        float64            `yaml:"default"`
+	Rules          []StatusRule       `yaml:"rules"`
+	Overrides      []StatusOverride   `yaml:"overrides"`
+	UseStatusCodes bool               `yaml:"use_status_codes"`
+	Strict         bool               `yaml:"strict"`
 }
 
 func init() {
 	prometheus.MustRegister(version.NewCollector("poweradmin_exporter"))
+	prometheus.MustRegister(seriesDroppedTotal)
+	prometheus.MustRegister(unmappedStatusTotal)
+	prometheus.MustRegister(sdErrorsTotal)
+	prometheus.MustRegister(configLastReloadSuccessful)
+	prometheus.MustRegister(configLastReloadSuccessTimestamp)
+	prometheus.MustRegister(eventsSentTotal)
+	prometheus.MustRegister(eventSendErrorsTotal)
+	prometheus.MustRegister(eventsDroppedTotal)
+	prometheus.MustRegister(alertmanagerAlertsSentTotal)
+	prometheus.MustRegister(alertmanagerErrorsTotal)
+	prometheus.MustRegister(alertActionsReceivedTotal)
+	prometheus.MustRegister(syslogMessagesTotal)
+	prometheus.MustRegister(syslogAlertsTotal)
+	prometheus.MustRegister(pushesTotal)
+	prometheus.MustRegister(pushErrorsTotal)
+	prometheus.MustRegister(pushQueueFiles)
+	prometheus.MustRegister(pushQueueDroppedTotal)
 }
 
 func main() {
 	kingpin.Version(version.Print("poweradmin_exporter"))
 	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
+	command := kingpin.Parse()
+
+	switch command {
+	case checkConfigCommand.FullCommand():
+		os.Exit(checkConfig(*configPath, *checkConfigProbe, os.Stdout))
+	case groupsCommand.FullCommand():
+		os.Exit(runInspect(*configPath, *instance, os.Stderr, func(client PAExternalAPI) error {
+			return printGroups(client, *groupsOutput, os.Stdout)
+		}))
+	case serversCommand.FullCommand():
+		os.Exit(runInspect(*configPath, *instance, os.Stderr, func(client PAExternalAPI) error {
+			return printServers(client, *serversGroup, *serversOutput, os.Stdout)
+		}))
+	case monitorsCommand.FullCommand():
+		os.Exit(runInspect(*configPath, *instance, os.Stderr, func(client PAExternalAPI) error {
+			return printMonitors(client, *monitorsGroup, *monitorsServer, *monitorsOutput, os.Stdout)
+		}))
+	}
 
 	log.Info("Starting exporter ", version.Info())
 	log.Info("Build context ", version.BuildContext())
 
-	config, err := loadConfig(*configPath)
+	reloader, err := NewReloader(*configPath)
 	if err != nil {
 		log.Fatalf("Error loading the config: %v", err)
 	}
-
-	skipTLS := false
-	if config.SkipTLSVerify {
-		skipTLS = true
+	if err := reloader.LoadState(); err != nil {
+		log.Errorf("Error loading the monitor states, starting without them: %v", err)
 	}
-	powerAdminClient, err := NewPAExternalAPIClient(config.APIKey, config.ServerURL, skipTLS)
-	if err != nil {
+	prometheus.MustRegister(reloader)
+	go reloader.WatchSignals()
+	go reloader.RunStateSaver()
+	go reloader.ExitOnSignal()
+	go reloader.RunFileSD()
+	go reloader.RunPush()
+	if err := reloader.ListenSyslog(); err != nil {
 		log.Fatal(err)
 	}
 
-	collector := NewCollector(powerAdminClient, config)
-	prometheus.MustRegister(collector)
-
 	http.Handle(*metricsPath, promhttp.Handler())
+	if *enableLifecycle {
+		http.Handle("/-/reload", reloader)
+	}
+	http.HandleFunc("/probe", reloader.ProbeHandler)
+	http.HandleFunc("/sd", reloader.SDHandler)
+	http.HandleFunc("/api/v1/monitors", reloader.APIMonitorsHandler)
+	http.HandleFunc("/api/v1/servers", reloader.APIServersHandler)
+	http.HandleFunc("/api/v1/groups", reloader.APIGroupsHandler)
+	http.HandleFunc("/webhook/poweradmin", reloader.WebhookHandler)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		_, err := w.Write([]byte(`<html>
 			<head><title>PowerAdmin Exporter</title></head>
@@ -84,7 +168,7 @@ func main() {
 	})
 
 	log.Infof("Beginning to serve on address %v", *listenAddress)
-	log.Fatal(http.ListenAndServe(*listenAddress, nil))
+	log.Fatal(listenAndServe(*listenAddress, *webConfigFile, http.DefaultServeMux))
 }
 
 func loadConfig(configDir string) (Config, error) {
@@ -94,19 +178,76 @@ func loadConfig(configDir string) (Config, error) {
 	if err != nil {
 		return configuration, err
 	}
-	configData := make([]byte, 0)
+	merged := make(map[interface{}]interface{})
+	origins := make(map[string]string)
 	for _, file := range files {
-		log.Infof("Parsing %s/%s config file", configDir, file.Name())
-		configThisData, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", configDir, file.Name()))
+		if file.IsDir() || !isConfigFile(file.Name()) {
+			log.Debugf("Skipping %s/%s which is not a YAML config file", configDir, file.Name())
+			continue
+		}
+		fileName := filepath.Join(configDir, file.Name())
+		log.Infof("Parsing %s config file", fileName)
+		configThisData, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return configuration, err
+		}
+		tree, err := parseConfigFile(configThisData)
+		if err != nil {
+			return configuration, fmt.Errorf("%s: %v", fileName, err)
+		}
+		// parse each file on its own first so that errors refer to the file
+		expandedData, err := yaml.Marshal(tree)
 		if err != nil {
+			return configuration, fmt.Errorf("%s: %v", fileName, err)
+		}
+		if err := yaml.UnmarshalStrict(expandedData, &Config{}); err != nil {
+			return configuration, fmt.Errorf("%s: %v", fileName, err)
+		}
+		if err := mergeConfigTree(merged, tree, "", fileName, origins); err != nil {
 			return configuration, err
 		}
-		configData = append(configData, configThisData...)
 	}
 
-	errYAML := yaml.Unmarshal([]byte(configData), &configuration)
+	configData, err := yaml.Marshal(merged)
+	if err != nil {
+		return configuration, err
+	}
+	errYAML := yaml.UnmarshalStrict(configData, &configuration)
 	if errYAML != nil {
 		return configuration, errYAML
 	}
+	if err := configuration.compile(); err != nil {
+		return configuration, err
+	}
+	if err := configuration.Alertmanager.validate(); err != nil {
+		return configuration, fmt.Errorf("alertmanager: %v", err)
+	}
+	if err := configuration.readAPIKeyFile(configDir); err != nil {
+		return configuration, err
+	}
+	configuration.TLSConfig.resolvePaths(configDir)
+	configuration.ServiceDiscovery.FileSD.Directory = resolveConfigPath(configDir, configuration.ServiceDiscovery.FileSD.Directory)
+	configuration.StatusTracking.StateFile = resolveConfigPath(configDir, configuration.StatusTracking.StateFile)
+	configuration.Push.Queue.Directory = resolveConfigPath(configDir, configuration.Push.Queue.Directory)
+	if err := configuration.Webhook.readTokenFile(configDir); err != nil {
+		return configuration, err
+	}
+	for i := range configuration.Events.Sinks {
+		configuration.Events.Sinks[i].Path = resolveConfigPath(configDir, configuration.Events.Sinks[i].Path)
+	}
+	if err := validateInstances(configuration.Instances); err != nil {
+		return configuration, err
+	}
+	for i := range configuration.Instances {
+		if err := configuration.Instances[i].loadFiles(configDir); err != nil {
+			return configuration, err
+		}
+	}
+	if err := validateModules(configuration); err != nil {
+		return configuration, err
+	}
+	if _, err := configuration.instanceConfig(configuration.Syslog.Instance); err != nil {
+		return configuration, fmt.Errorf("syslog: %v", err)
+	}
 	return configuration, nil
 }
@@ -1,6 +1,13 @@
 package main
 
-import "testing"
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
 
 func TestMain_LoadConfig(t *testing.T) {
 
@@ -26,3 +33,99 @@ func TestMain_LoadConfig_ShouldRaiseError(t *testing.T) {
 		t.Errorf("A nonexistent folder didn't raise an error")
 	}
 }
+
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "poweradmin_exporter")
+	if err != nil {
+		t.Fatalf("Error creating the config folder: %v", err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("Error writing the config file %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+func TestLoadConfig_MergeFiles(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.yml":      "server: \"https://paserver\"\ngroup:\n  - path: \"Dev\"",
+		"b.yaml":     "group:\n  - path: \"Prod\"\nstatusMapping:\n  values:\n    \"ok\": 1",
+		"c.yml":      "server: \"https://paserver\"\nstatusMapping:\n  values:\n    \"alert\": 2\n  default: 0\n",
+		"d.yml~":     "server: \"https://otherserver\"",
+		"README.txt": "not yaml: [",
+	})
+	defer os.RemoveAll(dir)
+
+	config, err := loadConfig(dir)
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	if config.ServerURL != "https://paserver" {
+		t.Errorf("Wrong server: got %v, want %v", config.ServerURL, "https://paserver")
+	}
+	if len(config.Groups) != 2 || config.Groups[0].GroupPath != "Dev" || config.Groups[1].GroupPath != "Prod" {
+		t.Errorf("Groups of both files should be merged: got %v", config.Groups)
+	}
+	if len(config.StatusMapping.Statuses) != 2 {
+		t.Errorf("Statuses of both files should be merged: got %v", config.StatusMapping.Statuses)
+	}
+}
+
+func TestLoadConfig_ConflictingScalars(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.yml": "statusMapping:\n  default: 0\n",
+		"b.yml": "statusMapping:\n  default: 1\n",
+	})
+	defer os.RemoveAll(dir)
+
+	_, err := loadConfig(dir)
+	if err == nil {
+		t.Fatalf("Conflicting values should raise an error")
+	}
+	if !strings.Contains(err.Error(), "b.yml") || !strings.Contains(err.Error(), "a.yml") || !strings.Contains(err.Error(), "statusMapping.default") {
+		t.Errorf("Error should refer to the files and the key: got %v", err)
+	}
+}
+
+func TestLoadConfig_UnknownField(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.yml": "server: \"https://paserver\"\nskip_tls_verfy: true\n",
+	})
+	defer os.RemoveAll(dir)
+
+	_, err := loadConfig(dir)
+	if err == nil {
+		t.Fatalf("An unknown field should raise an error")
+	}
+	if !strings.Contains(err.Error(), "a.yml") {
+		t.Errorf("Error should refer to the file: got %v", err)
+	}
+}
+
+func TestLoadConfig_Compile(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.yml": "statusMapping:\n  rules:\n    - regex: \"^error\"\n      value: 3\n",
+		"b.yml": "modules:\n  dev:\n    statusMapping:\n      rules:\n        - regex: \"(\"\n          value: 3\n",
+	})
+	defer os.RemoveAll(dir)
+
+	_, err := loadConfig(dir)
+	if err == nil || !strings.Contains(err.Error(), "module dev") {
+		t.Fatalf("An invalid regex should raise an error referring to the module: got %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "b.yml"), []byte("syslog:\n  allowed_sources: [\"10.0.0.0/8\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadConfig(dir)
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	if value, _ := config.StatusMapping.mapStatus(MonitoredValue{MonitorValue: "Error"}); value != 3 {
+		t.Errorf("The rules should be compiled: got %v, want %v", value, 3)
+	}
+	if !config.Syslog.allowed(&net.UDPAddr{IP: net.ParseIP("10.1.2.3")}) {
+		t.Error("The allowed sources should be parsed")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const otherMonitorType = "other"
+
+var (
+	validMonitorTypeName = regexp.MustCompile("^[a-z_][a-z0-9_]*$")
+	// builtinMonitorTypes classify the monitors from their default PowerAdmin titles
+	builtinMonitorTypes = []MonitorTypeRule{
+		newMonitorTypeRule("ping", `(?i)^ping\b`),
+		newMonitorTypeRule("disk_space", `(?i)^disk space\b`),
+		newMonitorTypeRule("service", `(?i)^service\b`),
+		newMonitorTypeRule("event_log", `(?i)^event log\b`),
+		newMonitorTypeRule("cpu", `(?i)^cpu\b`),
+		newMonitorTypeRule("memory", `(?i)^memory\b`),
+		newMonitorTypeRule("process", `(?i)^process\b`),
+		newMonitorTypeRule("web_page", `(?i)^(web page|url|http)\b`),
+		newMonitorTypeRule("file", `(?i)^(file|directory|folder)\b`),
+		newMonitorTypeRule("snmp", `(?i)^snmp\b`),
+		newMonitorTypeRule("performance_counter", `(?i)^performance counter\b`),
+	}
+)
+
+// MonitorTypeConfig classification of the monitors by their title
+type MonitorTypeConfig struct {
+	Enabled        bool              `yaml:"enabled"`
+	DisableBuiltin bool              `yaml:"disable_builtin"`
+	TypeFamilies   bool              `yaml:"type_families"`
+	Types          []MonitorTypeRule `yaml:"types"`
+}
+
+// MonitorTypeRule monitors with a title matching the regex are of the named type
+type MonitorTypeRule struct {
+	Name  string `yaml:"name"`
+	Regex string `yaml:"regex"`
+	regex *regexp.Regexp
+}
+
+func newMonitorTypeRule(name string, regex string) MonitorTypeRule {
+	return MonitorTypeRule{Name: name, Regex: regex, regex: regexp.MustCompile(regex)}
+}
+
+// compile checks the names of the configured types and compiles their regexes
+func (c *MonitorTypeConfig) compile() error {
+	for i := range c.Types {
+		if err := c.Types[i].compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *MonitorTypeRule) compile() error {
+	if !validMonitorTypeName.MatchString(r.Name) {
+		return fmt.Errorf("invalid monitor type name %q, it must match %s", r.Name, validMonitorTypeName)
+	}
+	regex, err := regexp.Compile(r.Regex)
+	if err != nil {
+		return fmt.Errorf("invalid regex %q for monitor type %s: %v", r.Regex, r.Name, err)
+	}
+	r.regex = regex
+	return nil
+}
+
+func (r *MonitorTypeRule) matches(title string) bool {
+	return r.regex.MatchString(title)
+}
+
+// classify returns the type of a monitor, the configured types are checked before the built-in ones
+func (c *MonitorTypeConfig) classify(title string) string {
+	for i := range c.Types {
+		if c.Types[i].matches(title) {
+			return c.Types[i].Name
+		}
+	}
+	if !c.DisableBuiltin {
+		for i := range builtinMonitorTypes {
+			if builtinMonitorTypes[i].matches(title) {
+				return builtinMonitorTypes[i].Name
+			}
+		}
+	}
+	return otherMonitorType
+}
+
+func getMonitorTypeMetricName(monitorType string) string {
+	return "poweradmin_" + monitorType + "_monitor_status"
+}
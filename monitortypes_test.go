@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestMonitorTypeConfig_Classify(t *testing.T) {
+	config := MonitorTypeConfig{}
+	err := yaml.Unmarshal([]byte(`
+enabled: true
+types:
+  - name: "iis"
+    regex: "W3SVC"
+`), &config)
+	if err == nil {
+		err = config.compile()
+	}
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	tests := map[string]string{
+		"Ping FXMACHINE1":  "ping",
+		"Disk Space C:":    "disk_space",
+		"Service: W3SVC":   "iis",
+		"Service: Spooler": "service",
+		"Event Log":        "event_log",
+		"Pinger":           otherMonitorType,
+		"Something else":   otherMonitorType,
+	}
+	for title, want := range tests {
+		if got := config.classify(title); got != want {
+			t.Errorf("Wrong type for %q: got %v, want %v", title, got, want)
+		}
+	}
+
+	config.DisableBuiltin = true
+	if got := config.classify("Ping FXMACHINE1"); got != otherMonitorType {
+		t.Errorf("Wrong type without built-in types: got %v, want %v", got, otherMonitorType)
+	}
+}
+
+func TestMonitorTypeRule_Compile_Errors(t *testing.T) {
+	invalid := []string{
+		`{name: "ping", regex: "("}`,
+		`{name: "Not Valid", regex: "^ping"}`,
+		`{regex: "^ping"}`,
+	}
+	for _, rule := range invalid {
+		typeRule := MonitorTypeRule{}
+		if err := yaml.Unmarshal([]byte(rule), &typeRule); err != nil {
+			t.Fatal(err)
+		}
+		if err := typeRule.compile(); err == nil {
+			t.Errorf("Rule %s should raise an error", rule)
+		}
+	}
+}
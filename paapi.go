@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/xml"
 	"errors"
@@ -8,78 +9,92 @@ import (
 	"github.com/prometheus/common/log"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
 const (
-	paServerString    = "%s?KEY=%s"
-	monitorInfoSuffix = "&API=GET_MONITOR_INFO&XML=1&CID=%s"
-	groupListSuffix   = "&API=GET_GROUP_LIST&XML=1"
-	serverListSuffix  = "&API=GET_SERVER_LIST&XML=1&GID=%s"
+	paServerString    = "%s?"
+	monitorInfoSuffix = "API=GET_MONITOR_INFO&XML=1&CID=%s"
+	groupListSuffix   = "API=GET_GROUP_LIST&XML=1"
+	serverListSuffix  = "API=GET_SERVER_LIST&XML=1&GID=%s"
+	apiKeyParameter   = "KEY"
+	redactedAPIKey    = "<redacted>"
+
+	// APIKeyQuery sends the API key in the query string of GET requests
+	APIKeyQuery = "query"
+	// APIKeyHeader sends the API key in a KEY header of GET requests
+	APIKeyHeader = "header"
+	// APIKeyPost sends the API key with the other parameters in the form body of POST requests
+	APIKeyPost = "post"
 )
 
 // MonitorInfos return of the GET_MONITOR_INFO call
 type MonitorInfos struct {
-	Infos []MonitorInfo `xml:"monitor"`
+	Infos []MonitorInfo `xml:"monitor" json:"monitors"`
 }
 
 // MonitorInfo return of the GET_MONITOR_INFO call
 type MonitorInfo struct {
-	ID      string `xml:"id,attr"`
-	Status  string `xml:"status,attr"`
-	Title   string `xml:"title,attr"`
-	LastRun paTime `xml:"lastRun,attr"`
+	ID      string `xml:"id,attr" json:"id"`
+	Status  string `xml:"status,attr" json:"status"`
+	Title   string `xml:"title,attr" json:"title"`
+	LastRun paTime `xml:"lastRun,attr" json:"lastRun"`
+	ErrText string `xml:"errText,attr" json:"errText"`
 }
 
 // GroupList return of the GET_GROUP_LIST call
 type GroupList struct {
-	Groups []Group `xml:"group"`
+	Groups []Group `xml:"group" json:"groups"`
 }
 
 // Group return of the GET_GROUP_LIST call
 type Group struct {
-	ID       string `xml:"id,attr"`
-	Name     string `xml:"name,attr"`
-	Path     string `xml:"path,attr"`
-	ParentID string `xml:"parentID,attr"`
+	ID       string `xml:"id,attr" json:"id"`
+	Name     string `xml:"name,attr" json:"name"`
+	Path     string `xml:"path,attr" json:"path"`
+	ParentID string `xml:"parentID,attr" json:"parentID"`
 }
 
 // ServerList return of the GET_Server_LIST call
 type ServerList struct {
-	Servers []Server `xml:"server"`
+	Servers []Server `xml:"server" json:"servers"`
 }
 
 // Server return of the GET_Server_LIST call
 type Server struct {
-	ID      string `xml:"id,attr"`
-	Name    string `xml:"name,attr"`
-	Alias   string `xml:"alias,attr"`
-	Status  string `xml:"status,attr"`
-	GroupID string `xml:"groupID,attr"`
-	Group   string `xml:"group,attr"`
+	ID      string `xml:"id,attr" json:"id"`
+	Name    string `xml:"name,attr" json:"name"`
+	Alias   string `xml:"alias,attr" json:"alias"`
+	Status  string `xml:"status,attr" json:"status"`
+	GroupID string `xml:"groupID,attr" json:"groupID"`
+	Group   string `xml:"group,attr" json:"group"`
 }
 
 // MonitoredValues the values retrieved
 type MonitoredValues struct {
-	Values []MonitoredValue
+	Values []MonitoredValue `json:"values"`
 }
 
 // MonitoredValue one value with its attributes
 type MonitoredValue struct {
-	MonitorID      string
-	MonitorTitle   string
-	MonitorValue   string
-	MonitorStatus  string
-	MonitorLastRun time.Time
-	ServerID       string
-	ServerName     string
-	GroupID        string
-	GroupName      string
-	GroupPath      string
+	MonitorID      string    `json:"monitorID"`
+	MonitorTitle   string    `json:"monitorTitle"`
+	MonitorValue   string    `json:"monitorValue"`
+	MonitorStatus  string    `json:"monitorStatus"`
+	MonitorLastRun time.Time `json:"monitorLastRun"`
+	MonitorErrText string    `json:"monitorErrText"`
+	ServerID       string    `json:"serverID"`
+	ServerName     string    `json:"serverName"`
+	GroupID        string    `json:"groupID"`
+	GroupName      string    `json:"groupName"`
+	GroupPath      string    `json:"groupPath"`
 }
 
 type paTime struct {
 	time.Time
+	raw string
 }
 
 func (c *paTime) UnmarshalXMLAttr(attr xml.Attr) error {
@@ -90,10 +105,19 @@ func (c *paTime) UnmarshalXMLAttr(attr xml.Attr) error {
 		log.Errorf("Error parsing %s", attr.Value)
 		parse = time.Now()
 	}
-	*c = paTime{parse}
+	*c = paTime{parse, attr.Value}
 	return nil
 }
 
+// setLocation reads the time again in location, PowerAdmin returning the local time of its server without time zone
+func (c *paTime) setLocation(location *time.Location) {
+	const shortForm = "02-01-2006 15:04:05"
+
+	if parse, err := time.ParseInLocation(shortForm, c.raw, location); err == nil {
+		c.Time = parse
+	}
+}
+
 // PAExternalAPI interface
 type PAExternalAPI interface {
 	GetMonitorInfos(cid string) (*MonitorInfos, error)
@@ -103,13 +127,19 @@ type PAExternalAPI interface {
 }
 
 // PAExternalAPIClient client for PowerAdmin External API struct
+// The URLs don't contain the API key, it is added to each request depending on APIKeyMethod.
 type PAExternalAPIClient struct {
 	APIKey         string
+	APIKeyMethod   string
 	ServerURL      string
 	MonitorInfoURL string
 	GroupListURL   string
 	ServerListURL  string
 	Client         *http.Client
+	// Location time zone of the PowerAdmin server, the last run times are read as UTC when nil
+	Location *time.Location
+	// ctx bounds the requests of a copy made by withContext
+	ctx context.Context
 }
 
 func createPAClient(apiKey string, serverURL string, skipTLSVerify bool) PAExternalAPIClient {
@@ -121,9 +151,10 @@ func createPAClient(apiKey string, serverURL string, skipTLSVerify bool) PAExter
 		}
 	}
 	return PAExternalAPIClient{
-		APIKey:    apiKey,
-		ServerURL: serverURL,
-		Client:    &http.Client{Transport: transCfg},
+		APIKey:       apiKey,
+		APIKeyMethod: APIKeyQuery,
+		ServerURL:    serverURL,
+		Client:       &http.Client{Transport: transCfg},
 	}
 }
 
@@ -134,7 +165,7 @@ func NewPAExternalAPIClient(apiKey string, serverURL string, skipTLSVerify bool)
 	}
 
 	pa := createPAClient(apiKey, serverURL, skipTLSVerify)
-	paURL := fmt.Sprintf(paServerString, serverURL, apiKey)
+	paURL := fmt.Sprintf(paServerString, serverURL)
 	pa.MonitorInfoURL = paURL + monitorInfoSuffix
 	pa.GroupListURL = paURL + groupListSuffix
 	pa.ServerListURL = paURL + serverListSuffix
@@ -144,7 +175,6 @@ func NewPAExternalAPIClient(apiKey string, serverURL string, skipTLSVerify bool)
 func sendRequest(req *http.Request, client *http.Client) ([]byte, error) {
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Errorf("Error sending request: %v", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
@@ -156,15 +186,67 @@ func sendRequest(req *http.Request, client *http.Client) ([]byte, error) {
 	return data, err
 }
 
-func getResponse(requestURL string, client *http.Client) ([]byte, error) {
-	req, err := http.NewRequest("GET", requestURL, nil)
+// newRequest builds the request for an API URL, adding the API key
+func (client *PAExternalAPIClient) newRequest(requestURL string) (*http.Request, error) {
+	switch client.APIKeyMethod {
+	case APIKeyHeader:
+		req, err := http.NewRequest("GET", requestURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(apiKeyParameter, client.APIKey)
+		return req, nil
+	case APIKeyPost:
+		parsedURL, err := url.Parse(requestURL)
+		if err != nil {
+			return nil, err
+		}
+		form := parsedURL.Query()
+		form.Set(apiKeyParameter, client.APIKey)
+		parsedURL.RawQuery = ""
+		req, err := http.NewRequest("POST", parsedURL.String(), strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	default:
+		return http.NewRequest("GET", requestURL+"&"+apiKeyParameter+"="+url.QueryEscape(client.APIKey), nil)
+	}
+}
+
+// withContext returns a copy of the client whose requests are canceled with ctx
+func (client *PAExternalAPIClient) withContext(ctx context.Context) *PAExternalAPIClient {
+	copied := *client
+	copied.ctx = ctx
+	return &copied
+}
+
+// redactError removes the API key from an error, as url errors contain the full URL
+func (client *PAExternalAPIClient) redactError(err error) error {
+	message := err.Error()
+	redacted := strings.Replace(message, client.APIKey, redactedAPIKey, -1)
+	redacted = strings.Replace(redacted, url.QueryEscape(client.APIKey), redactedAPIKey, -1)
+	if redacted == message {
+		return err
+	}
+	return errors.New(redacted)
+}
+
+func (client *PAExternalAPIClient) getResponse(requestURL string) ([]byte, error) {
+	req, err := client.newRequest(requestURL)
 	if err != nil {
+		err = client.redactError(err)
 		log.Errorf("Error building request: %v", err)
 		return nil, err
 	}
-	resp, err := sendRequest(req, client)
+	if client.ctx != nil {
+		req = req.WithContext(client.ctx)
+	}
+	resp, err := sendRequest(req, client.Client)
 	if err != nil {
-		log.Errorf("Error querying %s: %s", req.RequestURI, err.Error())
+		err = client.redactError(err)
+		log.Errorf("Error querying %s: %s", requestURL, err.Error())
 		return nil, err
 	}
 	return resp, err
@@ -172,7 +254,7 @@ func getResponse(requestURL string, client *http.Client) ([]byte, error) {
 
 // GetMonitorInfos returns monitorinfos for a cid
 func (client *PAExternalAPIClient) GetMonitorInfos(cid string) (*MonitorInfos, error) {
-	resp, err := getResponse(fmt.Sprintf(client.MonitorInfoURL, cid), client.Client)
+	resp, err := client.getResponse(fmt.Sprintf(client.MonitorInfoURL, cid))
 	if err != nil {
 		return nil, err
 	}
@@ -182,12 +264,17 @@ func (client *PAExternalAPIClient) GetMonitorInfos(cid string) (*MonitorInfos, e
 		log.Errorf("Error unmarshalling response %s", err)
 		return nil, err
 	}
+	if client.Location != nil {
+		for i := range monitors.Infos {
+			monitors.Infos[i].LastRun.setLocation(client.Location)
+		}
+	}
 	return monitors, nil
 }
 
 // GetGroupList returns all groups
 func (client *PAExternalAPIClient) GetGroupList() (*GroupList, error) {
-	resp, err := getResponse(client.GroupListURL, client.Client)
+	resp, err := client.getResponse(client.GroupListURL)
 	if err != nil {
 		return nil, err
 	}
@@ -202,7 +289,7 @@ func (client *PAExternalAPIClient) GetGroupList() (*GroupList, error) {
 
 // GetServerList returns all groups
 func (client *PAExternalAPIClient) GetServerList(gid string) (*ServerList, error) {
-	resp, err := getResponse(fmt.Sprintf(client.ServerListURL, gid), client.Client)
+	resp, err := client.getResponse(fmt.Sprintf(client.ServerListURL, gid))
 	if err != nil {
 		return nil, err
 	}
@@ -262,6 +349,7 @@ func (client *PAExternalAPIClient) GetResources(groupFilters []GroupFilter) (*Mo
 							MonitorStatus:  metric.Status,
 							MonitorTitle:   metric.Title,
 							MonitorLastRun: metric.LastRun.Time,
+							MonitorErrText: metric.ErrText,
 							MonitorID:      metric.ID,
 						}
 						metrics.Values = append(metrics.Values, newMetric)
@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 const (
@@ -407,3 +408,83 @@ func TestPAExternalAPIClient_GetResources_DuplicateMonitor(t *testing.T) {
 		t.Errorf("Wrong value for metrics.Values[0].MonitorValue: got %v, want %v", metrics.Values[0].MonitorValue, "OK")
 	}
 }
+
+func TestPAExternalAPIClient_RedactAPIKey(t *testing.T) {
+	client, _ := NewPAExternalAPIClient("secret/key", "https://nourl.com", false)
+	if strings.Contains(client.MonitorInfoURL, "secret") || strings.Contains(client.GroupListURL, "secret") || strings.Contains(client.ServerListURL, "secret") {
+		t.Errorf("URLs shouldn't contain the API key: got %v", client.MonitorInfoURL)
+	}
+	_, err := client.GetMonitorInfos("ALL")
+	if err == nil {
+		t.Fatalf("Error shouldn't be nil: got %v", err)
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("Error shouldn't contain the API key: got %v", err)
+	}
+	if !strings.Contains(err.Error(), redactedAPIKey) {
+		t.Errorf("Error should contain %v: got %v", redactedAPIKey, err)
+	}
+}
+
+func TestPAExternalAPIClient_APIKeyMethods(t *testing.T) {
+	for _, method := range []string{APIKeyQuery, APIKeyHeader, APIKeyPost} {
+		groupHandler := func(w http.ResponseWriter, r *http.Request) {
+			var key, api string
+			switch method {
+			case APIKeyHeader:
+				key, api = r.Header.Get("KEY"), r.URL.Query().Get("API")
+			case APIKeyPost:
+				if r.Method != "POST" || r.URL.RawQuery != "" {
+					t.Errorf("%s: parameters should be sent in the body: got %s %s", method, r.Method, r.URL)
+				}
+				key, api = r.PostFormValue("KEY"), r.PostFormValue("API")
+			default:
+				key, api = r.URL.Query().Get("KEY"), r.URL.Query().Get("API")
+			}
+			if key != "1234key" {
+				t.Errorf("%s: wrong API key: got %v, want %v", method, key, "1234key")
+			}
+			if api != "GET_GROUP_LIST" {
+				t.Errorf("%s: wrong API: got %v, want %v", method, api, "GET_GROUP_LIST")
+			}
+			_, _ = w.Write([]byte(groupListString))
+		}
+		ts := httptest.NewServer(http.HandlerFunc(groupHandler))
+		client, _ := NewPAExternalAPIClient("1234key", ts.URL, false)
+		client.APIKeyMethod = method
+		groups, err := client.GetGroupList()
+		if err != nil {
+			t.Errorf("%s: error should be nil: got %v", method, err)
+		} else if len(groups.Groups) != 3 {
+			t.Errorf("%s: wrong size for groups.Groups: got %v, want %v", method, len(groups.Groups), 3)
+		}
+		ts.Close()
+	}
+}
+
+func TestNewPAExternalAPIClient_GetMonitorInfos_Location(t *testing.T) {
+	monitorHandler := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(monitorString))
+	}
+	ts := httptest.NewServer(http.HandlerFunc(monitorHandler))
+	defer ts.Close()
+	client, err := newClientFromConfig(Config{APIKey: "1234key", ServerURL: ts.URL, Timezone: "America/Montreal"})
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	monitors, err := client.GetMonitorInfos("ALL")
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	// 13:18:28 in Montreal in April is 17:18:28 UTC
+	want := time.Date(2019, time.April, 10, 17, 18, 28, 0, time.UTC)
+	if got := monitors.Infos[0].LastRun.Time; !got.Equal(want) {
+		t.Errorf("Wrong last run: got %v, want %v", got.UTC(), want)
+	}
+}
+
+func TestNewClientFromConfig_Timezone(t *testing.T) {
+	if _, err := newClientFromConfig(Config{APIKey: "1234key", ServerURL: "https://serverpa", Timezone: "Mars/Olympus"}); err == nil {
+		t.Error("An unknown timezone should fail")
+	}
+}
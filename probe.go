@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/log"
+)
+
+// probeTimeoutOffset is kept from the scrape timeout to send the response
+const probeTimeoutOffset = 500 * time.Millisecond
+
+var (
+	probeSuccessDesc  = prometheus.NewDesc("poweradmin_probe_success", "Whether the probe of the PowerAdmin group was successful", nil, nil)
+	probeDurationDesc = prometheus.NewDesc("poweradmin_probe_duration_seconds", "Duration of the probe of the PowerAdmin group", nil, nil)
+)
+
+// ModuleConfig settings of a /probe module, the settings not set are taken from the config of the instance
+type ModuleConfig struct {
+	Instance      string             `yaml:"instance"`
+	StatusMapping *StatusConfig      `yaml:"statusMapping"`
+	Limits        *LimitsConfig      `yaml:"limits"`
+	Timestamps    *TimestampConfig   `yaml:"timestamps"`
+	MonitorTypes  *MonitorTypeConfig `yaml:"monitor_types"`
+}
+
+// validateModules checks that the modules refer to existing instances
+func validateModules(config Config) error {
+	for name, module := range config.Modules {
+		if _, err := config.instanceConfig(module.Instance); err != nil {
+			return fmt.Errorf("module %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// groupConfig returns the config of the module restricted to the group and optionally to one server of the group
+func (m *ModuleConfig) groupConfig(config Config, group string, server string) Config {
+	if m.StatusMapping != nil {
+		config.StatusMapping = *m.StatusMapping
+	}
+	if m.Limits != nil {
+		config.Limits = *m.Limits
+	}
+	if m.Timestamps != nil {
+		config.Timestamps = *m.Timestamps
+	}
+	if m.MonitorTypes != nil {
+		config.MonitorTypes = *m.MonitorTypes
+	}
+	filter := GroupFilter{GroupPath: group}
+	if server != "" {
+		filter.Servers = []string{server}
+	}
+	config.Groups = []GroupFilter{filter}
+	return config
+}
+
+// probeCollector collects one probe and reports its success instead of failing the scrape
+type probeCollector struct {
+	collector *Collector
+}
+
+// Describe to satisfy the collector interface.
+func (p probeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- prometheus.NewDesc("dummy", "dummy", nil, nil)
+}
+
+// Collect metrics of the probed group
+func (p probeCollector) Collect(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	success := 1.0
+	if err := p.collector.collect(ch); err != nil {
+		log.Errorf("Probe of group %s failed: %v", p.collector.Config.Groups[0].GroupPath, err)
+		success = 0
+	}
+	ch <- prometheus.MustNewConstMetric(probeDurationDesc, prometheus.GaugeValue, time.Since(start).Seconds())
+	ch <- prometheus.MustNewConstMetric(probeSuccessDesc, prometheus.GaugeValue, success)
+}
+
+// instanceCollector returns the collector of the named instance, the first one when name is empty
+func (r *Reloader) instanceCollector(name string) (*Collector, error) {
+	collectors := r.Collectors()
+	if name == "" {
+		return collectors[0], nil
+	}
+	for _, collector := range collectors {
+		if collector.Config.InstanceName == name {
+			return collector, nil
+		}
+	}
+	return nil, fmt.Errorf("instance %q not found", name)
+}
+
+// ProbeHandler serves /probe?module=<name>&group=<path>[&server=<name>], collecting the group with the module settings
+func (r *Reloader) ProbeHandler(w http.ResponseWriter, req *http.Request) {
+	params := req.URL.Query()
+	group := params.Get("group")
+	if group == "" {
+		http.Error(w, "group parameter is missing", http.StatusBadRequest)
+		return
+	}
+	module := ModuleConfig{}
+	if moduleName := params.Get("module"); moduleName != "" {
+		var exists bool
+		module, exists = r.Config().Modules[moduleName]
+		if !exists {
+			http.Error(w, fmt.Sprintf("unknown module %q", moduleName), http.StatusBadRequest)
+			return
+		}
+	}
+	collector, err := r.instanceCollector(module.Instance)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the PowerAdmin requests stop when Prometheus gives up the scrape
+	ctx := req.Context()
+	if timeout, err := scrapeTimeout(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	client := collector.PowerAdminClient
+	if paClient, ok := client.(*PAExternalAPIClient); ok {
+		client = paClient.withContext(ctx)
+	}
+	probe := NewCollector(client, module.groupConfig(collector.Config, group, params.Get("server")))
+	probe.warned = collector.warned
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(probeCollector{collector: probe})
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, req)
+}
+
+// scrapeTimeout returns the scrape timeout sent by Prometheus less an offset, 0 when the header is not set
+func scrapeTimeout(req *http.Request) (time.Duration, error) {
+	header := req.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if header == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("invalid X-Prometheus-Scrape-Timeout-Seconds header %q", header)
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > 2*probeTimeoutOffset {
+		timeout -= probeTimeoutOffset
+	}
+	return timeout, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReloader_ProbeHandler(t *testing.T) {
+	api := MockPAExternalAPI{}
+	values := MonitoredValues{Values: []MonitoredValue{{
+		MonitorTitle:   "Toto",
+		MonitorValue:   "OK",
+		MonitorLastRun: time.Now(),
+		MonitorStatus:  "OK",
+		ServerName:     "Server1",
+		GroupPath:      "Dev",
+	}}}
+	api.On("GetResources", []GroupFilter{{GroupPath: "Dev", Servers: []string{"Server1"}}}).Return(&values, nil)
+	api.On("GetResources", []GroupFilter{{GroupPath: "Prod"}}).Return((*MonitoredValues)(nil), errors.New("PowerAdmin is down"))
+
+	mapping := StatusConfig{Statuses: map[string]float64{"ok": 5}}
+	reloader := &Reloader{
+		config:     Config{Modules: map[string]ModuleConfig{"strict": {StatusMapping: &mapping}}},
+		collectors: []*Collector{NewCollector(&api, Config{Groups: []GroupFilter{{GroupPath: "Other"}}})},
+	}
+
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantBody   []string
+	}{
+		{"module=strict", http.StatusBadRequest, []string{"group parameter is missing"}},
+		{"module=unknown&group=Dev", http.StatusBadRequest, []string{"unknown module"}},
+		{"module=strict&group=Dev&server=Server1", http.StatusOK, []string{`toto_status{group_path="Dev",server_name="Server1"} 5`, "poweradmin_probe_success 1"}},
+		{"group=Prod", http.StatusOK, []string{"poweradmin_probe_success 0"}},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		reloader.ProbeHandler(recorder, httptest.NewRequest(http.MethodGet, "/probe?"+test.query, nil))
+		if recorder.Code != test.wantStatus {
+			t.Errorf("Wrong status for %s: got %v, want %v", test.query, recorder.Code, test.wantStatus)
+		}
+		body, _ := ioutil.ReadAll(recorder.Body)
+		for _, want := range test.wantBody {
+			if !strings.Contains(string(body), want) {
+				t.Errorf("Missing %q in the response for %s: got %s", want, test.query, body)
+			}
+		}
+	}
+}
+
+func TestLoadConfig_UnknownModuleInstance(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"config.yml": "server: \"https://paserver\"\napi_key: \"1234\"\nmodules:\n  dr:\n    instance: \"dr\"\n",
+	})
+	defer os.RemoveAll(dir)
+
+	if _, err := loadConfig(dir); err == nil || !strings.Contains(err.Error(), "module dr") {
+		t.Errorf("A module of an unknown instance should raise an error: got %v", err)
+	}
+}
+
+func TestScrapeTimeout(t *testing.T) {
+	tests := []struct {
+		header  string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"10", 9500 * time.Millisecond, false},
+		{"0.5", 500 * time.Millisecond, false},
+		{"abc", 0, true},
+		{"-1", 0, true},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/probe", nil)
+		if test.header != "" {
+			req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", test.header)
+		}
+		got, err := scrapeTimeout(req)
+		if got != test.want || (err != nil) != test.wantErr {
+			t.Errorf("Wrong timeout for %q: got %v, %v, want %v", test.header, got, err, test.want)
+		}
+	}
+}
+
+func TestReloader_ProbeHandler_ScrapeTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer ts.Close()
+	client, _ := NewPAExternalAPIClient("1234key", ts.URL, false)
+	reloader := &Reloader{collectors: []*Collector{NewCollector(client, Config{})}}
+
+	req := httptest.NewRequest(http.MethodGet, "/probe?group=Dev", nil)
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "0.2")
+	recorder := httptest.NewRecorder()
+	start := time.Now()
+	reloader.ProbeHandler(recorder, req)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("The probe should stop at the scrape timeout: took %v", elapsed)
+	}
+	if body := recorder.Body.String(); !strings.Contains(body, "poweradmin_probe_success 0") {
+		t.Errorf("The probe should fail: got %s", body)
+	}
+}
+
+func TestReloader_ProbeHandler_WarnedStatuses(t *testing.T) {
+	api := MockPAExternalAPI{}
+	values := MonitoredValues{Values: []MonitoredValue{{MonitorTitle: "Toto", MonitorValue: "Training", MonitorStatus: "Training", ServerName: "Server1", GroupPath: "Dev"}}}
+	api.On("GetResources", []GroupFilter{{GroupPath: "Dev"}}).Return(&values, nil)
+	collector := NewCollector(&api, Config{})
+	reloader := &Reloader{collectors: []*Collector{collector}}
+
+	reloader.ProbeHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/probe?group=Dev", nil))
+	if _, warned := collector.warned.statuses["Training"]; !warned {
+		t.Errorf("The probes should share the warned statuses of the instance: got %v", collector.warned.statuses)
+	}
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const (
+	pushTargetPushgateway = "pushgateway"
+	pushTargetRemoteWrite = "remote_write"
+
+	defaultPushInterval     = time.Minute
+	defaultPushJob          = "poweradmin_exporter"
+	defaultPushTimeout      = 10 * time.Second
+	defaultPushMaxRetries   = 3
+	defaultPushRetryBackoff = time.Second
+	defaultPushQueueFiles   = 100
+	pushQueueFileSuffix     = ".snappy"
+)
+
+var (
+	pushesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "poweradmin_pushes_total",
+		Help: "Number of successful pushes, by target: pushgateway or remote_write",
+	}, []string{"target"})
+	pushErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "poweradmin_push_errors_total",
+		Help: "Number of pushes failing after all the retries, by target: pushgateway or remote_write",
+	}, []string{"target"})
+	pushQueueFiles = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "poweradmin_push_queue_files",
+		Help: "Number of remote_write requests waiting in the on-disk queue",
+	})
+	pushQueueDroppedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "poweradmin_push_queue_dropped_total",
+		Help: "Number of remote_write requests dropped because the on-disk queue was full",
+	})
+)
+
+// PushConfig the push mode, collecting on an interval and sending the metrics to a Pushgateway and/or a remote_write endpoint
+type PushConfig struct {
+	Interval     time.Duration    `yaml:"interval"`
+	Job          string           `yaml:"job"`
+	Pushgateway  PushTargetConfig `yaml:"pushgateway"`
+	RemoteWrite  PushTargetConfig `yaml:"remote_write"`
+	Timeout      time.Duration    `yaml:"timeout"`
+	MaxRetries   *int             `yaml:"max_retries"`
+	RetryBackoff time.Duration    `yaml:"retry_backoff"`
+	Queue        PushQueueConfig  `yaml:"queue"`
+}
+
+// PushTargetConfig an endpoint receiving the pushed metrics
+type PushTargetConfig struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// PushQueueConfig the on-disk queue of the remote_write requests which failed, disabled when no directory is set
+type PushQueueConfig struct {
+	Directory string `yaml:"directory"`
+	MaxFiles  int    `yaml:"max_files"`
+}
+
+// UnmarshalYAML checks the Pushgateway and remote_write URLs
+func (c *PushConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain PushConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	for target, pushURL := range map[string]string{pushTargetPushgateway: c.Pushgateway.URL, pushTargetRemoteWrite: c.RemoteWrite.URL} {
+		if pushURL == "" {
+			continue
+		}
+		parsed, err := url.Parse(pushURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return fmt.Errorf("invalid %s url %q", target, pushURL)
+		}
+	}
+	return nil
+}
+
+func (c *PushConfig) enabled() bool {
+	return c.Pushgateway.URL != "" || c.RemoteWrite.URL != ""
+}
+
+func (c *PushConfig) interval() time.Duration {
+	if c.Interval <= 0 {
+		return defaultPushInterval
+	}
+	return c.Interval
+}
+
+func (c *PushConfig) job() string {
+	if c.Job == "" {
+		return defaultPushJob
+	}
+	return c.Job
+}
+
+// pusher sends the pushes of one interval
+type pusher struct {
+	config PushConfig
+	client *http.Client
+	// retryBackoff is doubled after each failed attempt
+	maxRetries   int
+	retryBackoff time.Duration
+	// deadline no request is sent or retried after it, so that a push doesn't overlap the next one
+	deadline time.Time
+}
+
+func newPusher(config PushConfig, deadline time.Time) *pusher {
+	p := &pusher{
+		config:       config,
+		client:       &http.Client{Timeout: config.Timeout},
+		maxRetries:   defaultPushMaxRetries,
+		retryBackoff: config.RetryBackoff,
+		deadline:     deadline,
+	}
+	if config.Timeout <= 0 {
+		p.client.Timeout = defaultPushTimeout
+	}
+	if config.MaxRetries != nil {
+		p.maxRetries = *config.MaxRetries
+	}
+	if config.RetryBackoff <= 0 {
+		p.retryBackoff = defaultPushRetryBackoff
+	}
+	return p
+}
+
+// permanentError an error that retrying the request won't fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// send sends the request, retrying the network errors and the 5xx and 429 statuses until the deadline
+func (p *pusher) send(method string, target PushTargetConfig, pushURL string, headers map[string]string, body []byte) error {
+	backoff := p.retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := p.do(method, target, pushURL, headers, body)
+		if _, permanent := err.(permanentError); err == nil || permanent || attempt >= p.maxRetries {
+			return err
+		}
+		if time.Now().Add(backoff).After(p.deadline) {
+			return fmt.Errorf("%v, not retried after the push interval", err)
+		}
+		log.Debugf("Push to %s failed, retrying in %s: %v", pushURL, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (p *pusher) do(method string, target PushTargetConfig, pushURL string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequest(method, pushURL, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), p.deadline)
+	defer cancel()
+	req = req.WithContext(ctx)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	for name, value := range target.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("%s returned status %s: %s", pushURL, resp.Status, strings.TrimSpace(string(message)))
+	if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests {
+		return permanentError{err}
+	}
+	return err
+}
+
+// gatherCollector collects the metrics of one instance
+func gatherCollector(collector *Collector) ([]*dto.MetricFamily, error) {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(collector); err != nil {
+		return nil, err
+	}
+	return registry.Gather()
+}
+
+// groupingPath returns the Pushgateway path part of a grouping label, the value being base64 encoded when it contains a slash
+func groupingPath(name string, value string) string {
+	if value == "" {
+		return "/" + name + "@base64/="
+	}
+	if strings.Contains(value, "/") {
+		return "/" + name + "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(value))
+	}
+	return "/" + name + "/" + url.PathEscape(value)
+}
+
+// pushToGateway replaces the metrics of the instance group in the Pushgateway
+func (p *pusher) pushToGateway(instance string, families []*dto.MetricFamily) error {
+	body := &bytes.Buffer{}
+	encoder := expfmt.NewEncoder(body, expfmt.FmtText)
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			// the Pushgateway rejects the samples with a timestamp
+			metric.TimestampMs = nil
+		}
+		if err := encoder.Encode(family); err != nil {
+			return err
+		}
+	}
+	pushURL := strings.TrimRight(p.config.Pushgateway.URL, "/") + "/metrics" + groupingPath("job", p.config.job())
+	if instance != "" {
+		pushURL += groupingPath(instanceLabel, instance)
+	}
+	return p.send(http.MethodPut, p.config.Pushgateway, pushURL, map[string]string{"Content-Type": string(expfmt.FmtText)}, body.Bytes())
+}
+
+// familySamples flattens the metric families into remote_write time series, the summaries and histograms being split into their series
+func familySamples(families []*dto.MetricFamily, job string, now time.Time) []prompb.TimeSeries {
+	series := make([]prompb.TimeSeries, 0)
+	defaultTimestamp := now.UnixNano() / int64(time.Millisecond)
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			timestamp := defaultTimestamp
+			if metric.TimestampMs != nil {
+				timestamp = metric.GetTimestampMs()
+			}
+			add := func(suffix string, value float64, extraName string, extraValue string) {
+				labels := make([]prompb.Label, 0, len(metric.Label)+3)
+				labels = append(labels, prompb.Label{Name: "__name__", Value: family.GetName() + suffix}, prompb.Label{Name: "job", Value: job})
+				for _, label := range metric.Label {
+					if label.GetName() != "job" {
+						labels = append(labels, prompb.Label{Name: label.GetName(), Value: label.GetValue()})
+					}
+				}
+				if extraName != "" {
+					labels = append(labels, prompb.Label{Name: extraName, Value: extraValue})
+				}
+				sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+				series = append(series, prompb.TimeSeries{Labels: labels, Samples: []prompb.Sample{{Value: value, Timestamp: timestamp}}})
+			}
+			switch {
+			case metric.Counter != nil:
+				add("", metric.Counter.GetValue(), "", "")
+			case metric.Gauge != nil:
+				add("", metric.Gauge.GetValue(), "", "")
+			case metric.Untyped != nil:
+				add("", metric.Untyped.GetValue(), "", "")
+			case metric.Summary != nil:
+				for _, quantile := range metric.Summary.Quantile {
+					add("", quantile.GetValue(), "quantile", formatFloat(quantile.GetQuantile()))
+				}
+				add("_sum", metric.Summary.GetSampleSum(), "", "")
+				add("_count", float64(metric.Summary.GetSampleCount()), "", "")
+			case metric.Histogram != nil:
+				for _, bucket := range metric.Histogram.Bucket {
+					add("_bucket", float64(bucket.GetCumulativeCount()), "le", formatFloat(bucket.GetUpperBound()))
+				}
+				add("_bucket", float64(metric.Histogram.GetSampleCount()), "le", "+Inf")
+				add("_sum", metric.Histogram.GetSampleSum(), "", "")
+				add("_count", float64(metric.Histogram.GetSampleCount()), "", "")
+			}
+		}
+	}
+	return series
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// encodeWriteRequest encodes the time series as a snappy compressed remote_write WriteRequest
+func encodeWriteRequest(series []prompb.TimeSeries) ([]byte, error) {
+	data, err := proto.Marshal(&prompb.WriteRequest{Timeseries: series})
+	if err != nil {
+		return nil, err
+	}
+	return snappy.Encode(nil, data), nil
+}
+
+var remoteWriteHeaders = map[string]string{
+	"Content-Encoding":                  "snappy",
+	"Content-Type":                      "application/x-protobuf",
+	"X-Prometheus-Remote-Write-Version": "0.1.0",
+}
+
+func (p *pusher) remoteWrite(body []byte) error {
+	return p.send(http.MethodPost, p.config.RemoteWrite, p.config.RemoteWrite.URL, remoteWriteHeaders, body)
+}
+
+// queuedFiles returns the files of the queue, oldest first
+func (p *pusher) queuedFiles() ([]string, error) {
+	files, err := ioutil.ReadDir(p.config.Queue.Directory)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), pushQueueFileSuffix) && !strings.HasPrefix(file.Name(), ".") {
+			names = append(names, filepath.Join(p.config.Queue.Directory, file.Name()))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// flushQueue sends the queued requests in order, it stops at the first failure to keep the samples in order
+func (p *pusher) flushQueue() (int, error) {
+	files, err := p.queuedFiles()
+	if err != nil {
+		return 0, err
+	}
+	for i, fileName := range files {
+		body, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return len(files) - i, err
+		}
+		err = p.remoteWrite(body)
+		if _, permanent := err.(permanentError); err != nil && !permanent {
+			return len(files) - i, err
+		}
+		if err != nil {
+			log.Errorf("Dropping the queued remote_write request %s: %v", fileName, err)
+			pushErrorsTotal.WithLabelValues(pushTargetRemoteWrite).Inc()
+		} else {
+			pushesTotal.WithLabelValues(pushTargetRemoteWrite).Inc()
+		}
+		if err := os.Remove(fileName); err != nil {
+			return len(files) - i, err
+		}
+	}
+	return 0, nil
+}
+
+// enqueue stores a failed request in the queue, the oldest requests are dropped when the queue is full
+func (p *pusher) enqueue(body []byte, now time.Time) error {
+	fileName := filepath.Join(p.config.Queue.Directory, fmt.Sprintf("%020d%s", now.UnixNano(), pushQueueFileSuffix))
+	if err := writeFileAtomic(fileName, body); err != nil {
+		return err
+	}
+	files, err := p.queuedFiles()
+	if err != nil {
+		return err
+	}
+	maxFiles := p.config.Queue.MaxFiles
+	if maxFiles <= 0 {
+		maxFiles = defaultPushQueueFiles
+	}
+	for len(files) > maxFiles {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		pushQueueDroppedTotal.Inc()
+		files = files[1:]
+	}
+	pushQueueFiles.Set(float64(len(files)))
+	return nil
+}
+
+// pushRemoteWrite sends the queued requests then the new one, the new one is queued when sending fails
+func (p *pusher) pushRemoteWrite(body []byte, now time.Time) error {
+	if p.config.Queue.Directory == "" {
+		return p.remoteWrite(body)
+	}
+	if err := os.MkdirAll(p.config.Queue.Directory, 0755); err != nil {
+		return err
+	}
+	remaining, err := p.flushQueue()
+	pushQueueFiles.Set(float64(remaining))
+	if err == nil {
+		err = p.remoteWrite(body)
+		if _, permanent := err.(permanentError); err == nil || permanent {
+			return err
+		}
+	}
+	if queueErr := p.enqueue(body, now); queueErr != nil {
+		return fmt.Errorf("%v, and queueing the request failed: %v", err, queueErr)
+	}
+	return fmt.Errorf("%v, the request is queued", err)
+}
+
+// Push collects all the instances and pushes their metrics, an instance failing doesn't stop the others.
+// The Pushgateway and remote_write targets are pushed to concurrently, so that a slow target doesn't delay the other.
+func (r *Reloader) Push(now time.Time) error {
+	config := r.Config().Push
+	if !config.enabled() {
+		return errors.New("push mode not configured")
+	}
+	p := newPusher(config, time.Now().Add(config.interval()))
+	var collectErr error
+	instances := make([]string, 0)
+	instanceFamilies := make([][]*dto.MetricFamily, 0)
+	series := make([]prompb.TimeSeries, 0)
+	for _, collector := range r.Collectors() {
+		instance := collector.Config.InstanceName
+		families, err := gatherCollector(collector)
+		if err != nil {
+			// the metrics of the previous push are kept rather than being replaced by incomplete ones
+			log.Errorf("Error collecting the metrics of instance %q to push: %v", instance, err)
+			if collectErr == nil {
+				collectErr = err
+			}
+			continue
+		}
+		if config.RemoteWrite.URL != "" {
+			series = append(series, familySamples(families, config.job(), now)...)
+		}
+		instances = append(instances, instance)
+		instanceFamilies = append(instanceFamilies, families)
+	}
+
+	var wg sync.WaitGroup
+	var gatewayErr, remoteWriteErr error
+	if config.Pushgateway.URL != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i, instance := range instances {
+				if err := p.pushToGateway(instance, instanceFamilies[i]); err != nil {
+					log.Errorf("Error pushing the metrics of instance %q to the Pushgateway: %v", instance, err)
+					pushErrorsTotal.WithLabelValues(pushTargetPushgateway).Inc()
+					if gatewayErr == nil {
+						gatewayErr = err
+					}
+				} else {
+					pushesTotal.WithLabelValues(pushTargetPushgateway).Inc()
+				}
+			}
+		}()
+	}
+	if config.RemoteWrite.URL != "" && len(series) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body, err := encodeWriteRequest(series)
+			if err == nil {
+				err = p.pushRemoteWrite(body, now)
+			}
+			if err != nil {
+				log.Errorf("Error sending the metrics to remote_write: %v", err)
+				pushErrorsTotal.WithLabelValues(pushTargetRemoteWrite).Inc()
+				remoteWriteErr = err
+			} else {
+				pushesTotal.WithLabelValues(pushTargetRemoteWrite).Inc()
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range []error{collectErr, gatewayErr, remoteWriteErr} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunPush pushes the metrics periodically, the config being read on each interval.
+// It returns at once when the config at startup has no push target, enabling the push mode needs a restart.
+func (r *Reloader) RunPush() {
+	if config := r.Config().Push; !config.enabled() {
+		return
+	}
+	for {
+		config := r.Config().Push
+		time.Sleep(config.interval())
+		config = r.Config().Push
+		if config.enabled() {
+			_ = r.Push(time.Now())
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/mock"
+)
+
+// pushReceiver a stand-in Pushgateway and remote_write receiver recording the requests, answering the queued statuses first
+type pushReceiver struct {
+	mutex    sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	statuses []int
+}
+
+func (r *pushReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	if len(r.statuses) > 0 {
+		status := r.statuses[0]
+		r.statuses = r.statuses[1:]
+		w.WriteHeader(status)
+	}
+}
+
+func newPushReloader(config PushConfig, instances ...string) *Reloader {
+	reloader := &Reloader{config: Config{Push: config}}
+	for _, instance := range instances {
+		api := &MockPAExternalAPI{}
+		api.On("GetResources", mock.Anything).Return(&MonitoredValues{Values: []MonitoredValue{
+			{MonitorTitle: "Ping", MonitorValue: "OK", MonitorStatus: "OK", ServerName: "FXH1", GroupPath: "Dev"},
+		}}, nil)
+		reloader.collectors = append(reloader.collectors, NewCollector(api, Config{InstanceName: instance, StatusMapping: StatusConfig{Default: 1}}))
+	}
+	return reloader
+}
+
+// decodeWriteRequest returns the remote_write samples as "labels value timestamp" lines
+func decodeWriteRequest(t *testing.T, body []byte) []string {
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatalf("Error decoding the snappy body: %v", err)
+	}
+	request := &prompb.WriteRequest{}
+	if err := proto.Unmarshal(data, request); err != nil {
+		t.Fatalf("Error decoding the WriteRequest: %v", err)
+	}
+	lines := make([]string, 0)
+	for _, series := range request.Timeseries {
+		labels := make([]string, 0)
+		for _, label := range series.Labels {
+			labels = append(labels, label.Name+"="+label.Value)
+		}
+		for _, sample := range series.Samples {
+			lines = append(lines, strings.Join(labels, ",")+" "+formatFloat(sample.Value)+" "+strconv.FormatInt(sample.Timestamp, 10))
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func TestReloader_Push_Pushgateway(t *testing.T) {
+	receiver := &pushReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	reloader := newPushReloader(PushConfig{
+		Job:         "pa",
+		Pushgateway: PushTargetConfig{URL: server.URL + "/", Headers: map[string]string{"Authorization": "Bearer secret"}},
+	}, "prod", "site/2")
+	reloader.collectors[0].Config.Timestamps.Enabled = true
+
+	if err := reloader.Push(time.Now()); err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	wantPaths := []string{"/metrics/job/pa/poweradmin_instance/prod", "/metrics/job/pa/poweradmin_instance@base64/c2l0ZS8y"}
+	if len(receiver.requests) != len(wantPaths) {
+		t.Fatalf("Wrong number of pushes: got %v, want %v", len(receiver.requests), len(wantPaths))
+	}
+	for i, req := range receiver.requests {
+		if req.Method != http.MethodPut || req.URL.EscapedPath() != wantPaths[i] || req.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Wrong push request: got %s %s %v", req.Method, req.URL.EscapedPath(), req.Header)
+		}
+		body := string(receiver.bodies[i])
+		if !strings.Contains(body, `server_name="FXH1"`) {
+			t.Errorf("The pushed metrics should contain the monitor: got %s", body)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+			if !strings.HasPrefix(line, "#") && len(strings.Fields(line[strings.LastIndex(line, "}")+1:])) != 1 {
+				t.Errorf("The pushed samples should not have a timestamp: got %s", line)
+			}
+		}
+	}
+}
+
+func TestReloader_Push_RemoteWrite(t *testing.T) {
+	receiver := &pushReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	reloader := newPushReloader(PushConfig{RemoteWrite: PushTargetConfig{URL: server.URL + "/api/v1/write"}}, "prod")
+	now := time.Unix(1551693600, 0)
+
+	if err := reloader.Push(now); err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	if len(receiver.requests) != 1 {
+		t.Fatalf("Wrong number of requests: got %v, want %v", len(receiver.requests), 1)
+	}
+	req := receiver.requests[0]
+	if req.Method != http.MethodPost || req.URL.Path != "/api/v1/write" || req.Header.Get("Content-Encoding") != "snappy" ||
+		req.Header.Get("Content-Type") != "application/x-protobuf" || req.Header.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" {
+		t.Errorf("Wrong remote_write request: got %s %s %v", req.Method, req.URL.Path, req.Header)
+	}
+	want := "__name__=" + getFormattedMetricName("Ping") + ",group_path=Dev,job=poweradmin_exporter,poweradmin_instance=prod,server_name=FXH1 1 1551693600000"
+	got := decodeWriteRequest(t, receiver.bodies[0])
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("Wrong samples: got %v, want %v", got, want)
+	}
+}
+
+func TestReloader_Push_Queue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "push_queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	receiver := &pushReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	maxRetries := 1
+	reloader := newPushReloader(PushConfig{
+		RemoteWrite:  PushTargetConfig{URL: server.URL},
+		MaxRetries:   &maxRetries,
+		RetryBackoff: time.Millisecond,
+		Queue:        PushQueueConfig{Directory: filepath.Join(dir, "queue"), MaxFiles: 2},
+	}, "prod")
+
+	// 3 failed pushes with a retry each, the oldest one being dropped from the queue
+	receiver.statuses = []int{500, 500, 500, 500, 503, 503}
+	droppedBefore := readMetric(pushQueueDroppedTotal).value
+	for i := 1; i <= 3; i++ {
+		if err := reloader.Push(time.Unix(int64(i), 0)); err == nil {
+			t.Errorf("The push %d should fail", i)
+		}
+	}
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "queue"))
+	if len(files) != 2 {
+		t.Fatalf("Wrong number of queued requests: got %v, want %v", len(files), 2)
+	}
+	if got := readMetric(pushQueueDroppedTotal).value; got != droppedBefore+1 {
+		t.Errorf("Wrong number of dropped requests: got %v, want %v", got, droppedBefore+1)
+	}
+
+	receiver.requests, receiver.bodies = nil, nil
+	if err := reloader.Push(time.Unix(4, 0)); err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	timestamps := make([]string, 0)
+	for _, body := range receiver.bodies {
+		sample := decodeWriteRequest(t, body)[0]
+		timestamps = append(timestamps, sample[strings.LastIndex(sample, " ")+1:])
+	}
+	if strings.Join(timestamps, ",") != "2000,3000,4000" {
+		t.Errorf("The queued requests should be sent first in order: got %v", timestamps)
+	}
+	if files, _ := ioutil.ReadDir(filepath.Join(dir, "queue")); len(files) != 0 {
+		t.Errorf("The queue should be empty: got %v files", len(files))
+	}
+}
+
+func TestPusher_Send_Permanent(t *testing.T) {
+	receiver := &pushReceiver{statuses: []int{400}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	p := newPusher(PushConfig{RetryBackoff: time.Millisecond}, time.Now().Add(time.Minute))
+	if err := p.send(http.MethodPost, PushTargetConfig{}, server.URL, nil, nil); err == nil {
+		t.Error("A 400 status should fail")
+	}
+	if len(receiver.requests) != 1 {
+		t.Errorf("A 400 status should not be retried: got %v requests", len(receiver.requests))
+	}
+}
+
+func TestPusher_Send_Deadline(t *testing.T) {
+	receiver := &pushReceiver{statuses: []int{500, 500, 500, 500, 500, 500}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	maxRetries := 5
+	p := newPusher(PushConfig{MaxRetries: &maxRetries, RetryBackoff: 100 * time.Millisecond}, time.Now().Add(250*time.Millisecond))
+	start := time.Now()
+	if err := p.send(http.MethodPost, PushTargetConfig{}, server.URL, nil, nil); err == nil {
+		t.Error("A 500 status should fail")
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("The retries should stop at the deadline: took %v", elapsed)
+	}
+	if len(receiver.requests) != 2 {
+		t.Errorf("Wrong number of requests: got %v, want %v", len(receiver.requests), 2)
+	}
+}
+
+func TestReloader_RunPush_Disabled(t *testing.T) {
+	done := make(chan struct{})
+	go func() {
+		newPushReloader(PushConfig{}, "prod").RunPush()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("RunPush should return when the push mode is not configured")
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+var (
+	configLastReloadSuccessful = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "poweradmin_exporter_config_last_reload_successful",
+		Help: "Whether the last configuration reload attempt was successful",
+	})
+	configLastReloadSuccessTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "poweradmin_exporter_config_last_reload_success_timestamp_seconds",
+		Help: "Timestamp of the last successful configuration reload",
+	})
+	powerAdminUpDesc = prometheus.NewDesc("poweradmin_up", "Whether the last collection from the PowerAdmin instance was successful", []string{instanceLabel}, nil)
+)
+
+// Reloader holds the config and the collectors of the PowerAdmin instances built from it, and rebuilds them when the config is reloaded
+type Reloader struct {
+	ConfigDir     string
+	config        Config
+	collectors    []*Collector
+	collectErrors *prometheus.CounterVec
+	tracker       *StatusTracker
+	events        *EventDispatcher
+	alerts        *AlertForwarder
+	overlay       *StatusOverlay
+	// fileSDRefresh wakes RunFileSD up after a successful reload
+	fileSDRefresh chan struct{}
+	mutex         sync.RWMutex
+	// reloadMutex prevents concurrent reloads while the config is parsed
+	reloadMutex sync.Mutex
+}
+
+// NewReloader loads the config and creates the collector
+func NewReloader(configDir string) (*Reloader, error) {
+	reloader := &Reloader{
+		ConfigDir: configDir,
+		tracker:   NewStatusTracker(),
+		alerts:    NewAlertForwarder(),
+		overlay:   NewStatusOverlay(),
+		collectErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "poweradmin_collect_errors_total",
+			Help: "Number of failed collections from the PowerAdmin instance",
+		}, []string{instanceLabel}),
+	}
+	if err := reloader.Reload(); err != nil {
+		return nil, err
+	}
+	// created after the first load, which RunFileSD writes when it starts
+	reloader.fileSDRefresh = make(chan struct{}, 1)
+	return reloader, nil
+}
+
+// newClientFromConfig creates the PowerAdmin client for a config
+func newClientFromConfig(config Config) (*PAExternalAPIClient, error) {
+	client, err := NewPAExternalAPIClient(config.APIKey, config.ServerURL, config.SkipTLSVerify)
+	if err != nil {
+		return nil, err
+	}
+	switch config.APIKeyMethod {
+	case "":
+	case APIKeyQuery, APIKeyHeader, APIKeyPost:
+		client.APIKeyMethod = config.APIKeyMethod
+	default:
+		return nil, fmt.Errorf("invalid api_key_method %q, must be one of %s, %s or %s", config.APIKeyMethod, APIKeyQuery, APIKeyHeader, APIKeyPost)
+	}
+	if config.Timezone != "" {
+		location, err := time.LoadLocation(config.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %v", config.Timezone, err)
+		}
+		client.Location = location
+	}
+	transport, err := newTransport(config)
+	if err != nil {
+		return nil, err
+	}
+	client.Client.Transport = transport
+	return client, nil
+}
+
+// Reload loads the config and swaps the collectors, the current ones are kept if the config is invalid
+func (r *Reloader) Reload() error {
+	r.reloadMutex.Lock()
+	defer r.reloadMutex.Unlock()
+
+	config, err := loadConfig(r.ConfigDir)
+	if err != nil {
+		configLastReloadSuccessful.Set(0)
+		return fmt.Errorf("error loading the config: %v", err)
+	}
+	collectors := make([]*Collector, 0, len(config.Instances))
+	for _, instanceConfig := range config.instanceConfigs() {
+		client, err := newClientFromConfig(instanceConfig)
+		if err != nil {
+			configLastReloadSuccessful.Set(0)
+			if instanceConfig.InstanceName != "" {
+				return fmt.Errorf("error creating the PowerAdmin client of instance %s: %v", instanceConfig.InstanceName, err)
+			}
+			return fmt.Errorf("error creating the PowerAdmin client: %v", err)
+		}
+		collector := NewCollector(client, instanceConfig)
+		collector.Tracker = r.tracker
+		collector.Alerts = r.alerts
+		collector.Overlay = r.overlay
+		collectors = append(collectors, collector)
+		r.collectErrors.WithLabelValues(instanceConfig.InstanceName)
+	}
+	events, err := NewEventDispatcher(config.Events)
+	if err != nil {
+		configLastReloadSuccessful.Set(0)
+		return fmt.Errorf("error creating the event sinks: %v", err)
+	}
+	for _, collector := range collectors {
+		collector.Events = events
+	}
+
+	instances := make([]string, 0, len(collectors))
+	for _, collector := range collectors {
+		instances = append(instances, collector.Config.InstanceName)
+	}
+	r.alerts.configure(config.Alertmanager, instances, time.Now())
+
+	r.mutex.Lock()
+	r.config = config
+	r.collectors = collectors
+	previousEvents := r.events
+	r.events = events
+	r.mutex.Unlock()
+	// the queued events of the previous sinks are delivered in the background
+	go previousEvents.Close()
+
+	configLastReloadSuccessful.Set(1)
+	configLastReloadSuccessTimestamp.Set(float64(time.Now().Unix()))
+	select {
+	case r.fileSDRefresh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Config returns the current config
+func (r *Reloader) Config() Config {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.config
+}
+
+// Collectors returns the current collectors, one per PowerAdmin instance
+func (r *Reloader) Collectors() []*Collector {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.collectors
+}
+
+// Describe to satisfy the collector interface.
+func (r *Reloader) Describe(ch chan<- *prometheus.Desc) {
+	ch <- prometheus.NewDesc("dummy", "dummy", nil, nil)
+}
+
+// Collect metrics from all the PowerAdmin instances concurrently
+func (r *Reloader) Collect(ch chan<- prometheus.Metric) {
+	var wg sync.WaitGroup
+	for _, collector := range r.Collectors() {
+		wg.Add(1)
+		go func(collector *Collector) {
+			defer wg.Done()
+			r.collectInstance(collector, ch)
+		}(collector)
+	}
+	wg.Wait()
+	r.collectErrors.Collect(ch)
+}
+
+// collectInstance collects the metrics of one instance, a failing named instance doesn't fail the scrape of the others
+func (r *Reloader) collectInstance(collector *Collector, ch chan<- prometheus.Metric) {
+	name := collector.Config.InstanceName
+	up := 1.0
+	if err := collector.collect(ch); err != nil {
+		up = 0
+		r.collectErrors.WithLabelValues(name).Inc()
+		if name == "" {
+			ch <- prometheus.NewInvalidMetric(powerAdminErrorDesc, err)
+		} else {
+			log.Errorf("Failed to collect instance %s: %v", name, err)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(powerAdminUpDesc, prometheus.GaugeValue, up, name)
+}
+
+// WatchSignals reloads the config each time the process receives SIGHUP
+func (r *Reloader) WatchSignals() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		log.Info("Received SIGHUP, reloading the config")
+		if err := r.Reload(); err != nil {
+			log.Errorf("Config reload failed, keeping the current config: %v", err)
+			continue
+		}
+		log.Info("Config reloaded")
+	}
+}
+
+// ExitOnSignal saves the monitor states, delivers the queued events for a bounded time and exits when the process is asked to stop.
+// The signals are left to their default handling when the config at startup has no state file and no event sink.
+func (r *Reloader) ExitOnSignal() {
+	if config := r.Config(); config.StatusTracking.StateFile == "" && len(config.Events.Sinks) == 0 {
+		return
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	received := <-stop
+	log.Infof("Received %s, shutting down", received)
+	r.mutex.RLock()
+	events := r.events
+	r.mutex.RUnlock()
+	exitCode := 0
+	if err := r.SaveState(); err != nil {
+		log.Errorf("Error saving the monitor states: %v", err)
+		exitCode = 1
+	}
+	if !events.CloseTimeout(shutdownEventsTimeout) {
+		log.Warnf("The queued status change events were not all delivered within %s", shutdownEventsTimeout)
+	}
+	os.Exit(exitCode)
+}
+
+// ServeHTTP reloads the config on POST requests
+func (r *Reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.Reload(); err != nil {
+		log.Errorf("Config reload failed, keeping the current config: %v", err)
+		http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+		return
+	}
+	log.Info("Config reloaded")
+}
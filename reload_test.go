@@ -0,0 +1,176 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestReloader_Reload(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"config.yml": "server: \"https://paserver\"\napi_key: \"1234\"\ngroup:\n  - path: \"Dev\"\n",
+	})
+	defer os.RemoveAll(dir)
+
+	reloader, err := NewReloader(dir)
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	if readMetric(configLastReloadSuccessful).value != 1 {
+		t.Errorf("Wrong value for the reload gauge: got %v, want %v", readMetric(configLastReloadSuccessful).value, 1)
+	}
+	collector := reloader.Collectors()[0]
+
+	err = ioutil.WriteFile(filepath.Join(dir, "config.yml"), []byte("server: \"https://paserver\"\napi_key: \"1234\"\ngroup:\n  - path: \"Prod\"\n"), 0600)
+	if err != nil {
+		t.Fatalf("Error writing the config: %v", err)
+	}
+	if err := reloader.Reload(); err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	if reloader.Config().Groups[0].GroupPath != "Prod" {
+		t.Errorf("Wrong group after reload: got %v, want %v", reloader.Config().Groups[0].GroupPath, "Prod")
+	}
+	if reloader.Collectors()[0] == collector {
+		t.Errorf("The collector should have been rebuilt")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "config.yml"), []byte("server: \"https://paserver\"\napi_key: \"\"\n"), 0600)
+	if err != nil {
+		t.Fatalf("Error writing the config: %v", err)
+	}
+	if err := reloader.Reload(); err == nil {
+		t.Errorf("A config without API key should raise an error")
+	}
+	if reloader.Config().Groups[0].GroupPath != "Prod" {
+		t.Errorf("The config should be kept after a failed reload: got %v", reloader.Config().Groups)
+	}
+	if readMetric(configLastReloadSuccessful).value != 0 {
+		t.Errorf("Wrong value for the reload gauge: got %v, want %v", readMetric(configLastReloadSuccessful).value, 0)
+	}
+}
+
+func TestReloader_ServeHTTP(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"config.yml": "server: \"https://paserver\"\napi_key: \"1234\"\n",
+	})
+	defer os.RemoveAll(dir)
+
+	reloader, err := NewReloader(dir)
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	tests := []struct {
+		method string
+		config string
+		want   int
+	}{
+		{http.MethodGet, "server: \"https://paserver\"\napi_key: \"1234\"\n", http.StatusMethodNotAllowed},
+		{http.MethodPost, "server: \"https://paserver\"\napi_key: \"1234\"\n", http.StatusOK},
+		{http.MethodPost, "server: [\n", http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		if err := ioutil.WriteFile(filepath.Join(dir, "config.yml"), []byte(test.config), 0600); err != nil {
+			t.Fatalf("Error writing the config: %v", err)
+		}
+		recorder := httptest.NewRecorder()
+		reloader.ServeHTTP(recorder, httptest.NewRequest(test.method, "/-/reload", nil))
+		if recorder.Code != test.want {
+			t.Errorf("Wrong status for %s: got %v, want %v", test.method, recorder.Code, test.want)
+		}
+	}
+}
+
+func TestReloader_Reload_APIKeyFile(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"config.yml": "server: \"https://paserver\"\napi_key_file: \"api_key\"\n",
+		"api_key":    "1234",
+	})
+	defer os.RemoveAll(dir)
+
+	reloader, err := NewReloader(dir)
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "api_key"), []byte("5678"), 0600); err != nil {
+		t.Fatalf("Error writing the API key: %v", err)
+	}
+	if err := reloader.Reload(); err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	if reloader.Config().APIKey != "5678" {
+		t.Errorf("The API key should be read again on reload: got %v, want %v", reloader.Config().APIKey, "5678")
+	}
+}
+
+func TestReloader_Collect_Instances(t *testing.T) {
+	resourcesHandler := func(w http.ResponseWriter, r *http.Request) {
+		apiParam := r.URL.Query()["API"][0]
+		if apiParam == "GET_GROUP_LIST" {
+			_, _ = w.Write([]byte(groupListString))
+		} else if apiParam == "GET_SERVER_LIST" {
+			_, _ = w.Write([]byte(serverListString))
+		} else if apiParam == "GET_MONITOR_INFO" {
+			_, _ = w.Write([]byte(monitorString))
+		}
+	}
+	ts := httptest.NewServer(http.HandlerFunc(resourcesHandler))
+	defer ts.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	dir := writeConfigFiles(t, map[string]string{
+		"config.yml": "api_key: \"1234\"\ngroup:\n  - path: \"Servers/Devices^Live^FX\"\ninstances:\n" +
+			"  - name: \"prod\"\n    server: \"" + ts.URL + "\"\n" +
+			"  - name: \"dr\"\n    server: \"" + failing.URL + "\"\n",
+	})
+	defer os.RemoveAll(dir)
+
+	reloader, err := NewReloader(dir)
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	if len(reloader.Collectors()) != 2 {
+		t.Fatalf("Wrong number of collectors: got %v, want %v", len(reloader.Collectors()), 2)
+	}
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		reloader.Collect(ch)
+		close(ch)
+	}()
+	up := make(map[string]float64)
+	statusInstances := make(map[string]int)
+	for m := range ch {
+		if m.Desc() == powerAdminErrorDesc {
+			t.Fatalf("A failing instance should not fail the scrape")
+		}
+		got := readMetric(m)
+		switch {
+		case m.Desc() == powerAdminUpDesc:
+			up[got.labels[instanceLabel]] = got.value
+		case got.metricType == dto.MetricType_UNTYPED:
+			statusInstances[got.labels[instanceLabel]]++
+		}
+	}
+	if up["prod"] != 1 || up["dr"] != 0 {
+		t.Errorf("Wrong poweradmin_up values: got %v", up)
+	}
+	if statusInstances["prod"] == 0 || statusInstances["dr"] != 0 {
+		t.Errorf("Only the prod instance should have status metrics: got %v", statusInstances)
+	}
+	if got := readCounter(reloader.collectErrors, "dr"); got != 1 {
+		t.Errorf("Wrong error count for instance dr: got %v, want %v", got, 1)
+	}
+	if got := readCounter(reloader.collectErrors, "prod"); got != 0 {
+		t.Errorf("Wrong error count for instance prod: got %v, want %v", got, 0)
+	}
+}
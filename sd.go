@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"text/template"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+const (
+	defaultAddressTemplate = "{{.Name}}"
+	metaLabelPrefix        = "__meta_poweradmin_"
+)
+
+var (
+	// defaultAddress the address template of the configs without address
+	defaultAddress = template.Must(parseAddressTemplate(defaultAddressTemplate))
+	sdErrorsTotal  = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "poweradmin_sd_errors_total",
+		Help: "Number of failed service discovery requests to the PowerAdmin instance",
+	}, []string{instanceLabel})
+)
+
+// ServiceDiscoveryConfig builds Prometheus targets from the PowerAdmin servers
+type ServiceDiscoveryConfig struct {
+	// Address template of the target host, executed with the Server and its GroupPath
+	Address string `yaml:"address"`
+	Port    int    `yaml:"port"`
+	// Groups selection of the discovered servers, the configured groups are used when empty
+	Groups []GroupFilter `yaml:"group"`
+	FileSD FileSDConfig  `yaml:"file_sd"`
+
+	address *template.Template
+}
+
+// TargetGroup a Prometheus http_sd and file_sd target group
+type TargetGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+// addressData the data the address template is executed with
+type addressData struct {
+	Server
+	GroupPath string
+}
+
+// compile checks the port and parses the address template
+func (c *ServiceDiscoveryConfig) compile() error {
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("invalid service discovery port %d", c.Port)
+	}
+	address, err := parseAddressTemplate(c.Address)
+	if err != nil {
+		return err
+	}
+	c.address = address
+	return nil
+}
+
+func parseAddressTemplate(address string) (*template.Template, error) {
+	if address == "" {
+		address = defaultAddressTemplate
+	}
+	parsed, err := template.New("address").Option("missingkey=error").Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service discovery address template %q: %v", address, err)
+	}
+	return parsed, nil
+}
+
+// target returns the address of the server target
+func (c *ServiceDiscoveryConfig) target(server Server, groupPath string) (string, error) {
+	address := c.address
+	if address == nil {
+		address = defaultAddress
+	}
+	var host bytes.Buffer
+	if err := address.Execute(&host, addressData{Server: server, GroupPath: groupPath}); err != nil {
+		return "", fmt.Errorf("error building the address of server %s: %v", server.Name, err)
+	}
+	if c.Port == 0 {
+		return host.String(), nil
+	}
+	return net.JoinHostPort(host.String(), strconv.Itoa(c.Port)), nil
+}
+
+// groupTargets the target groups discovered for one group filter
+type groupTargets struct {
+	GroupPath    string
+	TargetGroups []TargetGroup
+}
+
+// discoverTargets returns one target group per server of the selected groups, labeled with the group and the server
+func discoverTargets(client PAExternalAPI, config Config) ([]TargetGroup, error) {
+	discovered, err := discoverGroupTargets(client, config)
+	if err != nil {
+		return nil, err
+	}
+	targetGroups := make([]TargetGroup, 0)
+	for _, group := range discovered {
+		targetGroups = append(targetGroups, group.TargetGroups...)
+	}
+	return targetGroups, nil
+}
+
+// discoverGroupTargets returns the target groups of each selected group, a group not found in PowerAdmin has no targets
+func discoverGroupTargets(client PAExternalAPI, config Config) ([]groupTargets, error) {
+	groupFilters := config.ServiceDiscovery.Groups
+	if len(groupFilters) == 0 {
+		groupFilters = config.Groups
+	}
+	groups, err := client.GetGroupList()
+	if err != nil {
+		return nil, err
+	}
+	groupSet := make(map[string]Group, len(groups.Groups))
+	for _, group := range groups.Groups {
+		groupSet[group.Path] = group
+	}
+	discovered := make([]groupTargets, 0, len(groupFilters))
+	for _, filter := range groupFilters {
+		targetGroups := make([]TargetGroup, 0)
+		group, groupExists := groupSet[filter.GroupPath]
+		if !groupExists {
+			log.Warnf("The configured group named %s was not found. It will be ignored.", filter.GroupPath)
+			discovered = append(discovered, groupTargets{GroupPath: filter.GroupPath, TargetGroups: targetGroups})
+			continue
+		}
+		servers, err := client.GetServerList(group.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, server := range filterServers(servers.Servers, filter.Servers) {
+			target, err := config.ServiceDiscovery.target(server, group.Path)
+			if err != nil {
+				return nil, err
+			}
+			labels := map[string]string{
+				metaLabelPrefix + "group_path":   group.Path,
+				metaLabelPrefix + "group_id":     group.ID,
+				metaLabelPrefix + "server_id":    server.ID,
+				metaLabelPrefix + "server_name":  server.Name,
+				metaLabelPrefix + "server_alias": server.Alias,
+			}
+			if config.InstanceName != "" {
+				labels[metaLabelPrefix+"instance"] = config.InstanceName
+			}
+			targetGroups = append(targetGroups, TargetGroup{Targets: []string{target}, Labels: labels})
+		}
+		discovered = append(discovered, groupTargets{GroupPath: group.Path, TargetGroups: targetGroups})
+	}
+	return discovered, nil
+}
+
+// SDHandler serves the targets of all the instances, or of the one of the instance parameter, in the Prometheus http_sd format.
+// A failing instance is left out so that the targets of the others are kept, the request fails when all the instances fail.
+func (r *Reloader) SDHandler(w http.ResponseWriter, req *http.Request) {
+	collectors := r.Collectors()
+	if name := req.URL.Query().Get("instance"); name != "" {
+		collector, err := r.instanceCollector(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		collectors = []*Collector{collector}
+	}
+	targetGroups := make([]TargetGroup, 0)
+	var lastErr error
+	failed := 0
+	for _, collector := range collectors {
+		name := collector.Config.InstanceName
+		instanceTargets, err := discoverTargets(collector.PowerAdminClient, collector.Config)
+		if err != nil {
+			if name != "" {
+				err = fmt.Errorf("instance %s: %v", name, err)
+			}
+			log.Errorf("Service discovery failed: %v", err)
+			sdErrorsTotal.WithLabelValues(name).Inc()
+			lastErr = err
+			failed++
+			continue
+		}
+		targetGroups = append(targetGroups, instanceTargets...)
+	}
+	if failed == len(collectors) {
+		http.Error(w, fmt.Sprintf("service discovery failed: %s", lastErr), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(targetGroups); err != nil {
+		log.Errorf("Error writing the service discovery response: %v", err)
+	}
+}
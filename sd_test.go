@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestServiceDiscoveryConfig_Target(t *testing.T) {
+	server := Server{ID: "568", Name: "FXH1", Alias: "fxh1.example.com"}
+	tests := []struct {
+		config string
+		want   string
+	}{
+		{"{}", "FXH1"},
+		{"port: 9182", "FXH1:9182"},
+		{"address: \"{{.Alias}}\"\nport: 9100", "fxh1.example.com:9100"},
+		{"address: \"{{.Name}}.corp.local\"", "FXH1.corp.local"},
+	}
+	for _, test := range tests {
+		config := ServiceDiscoveryConfig{}
+		if err := yaml.UnmarshalStrict([]byte(test.config), &config); err != nil {
+			t.Fatalf("Error should be nil for %s: got %v", test.config, err)
+		}
+		if err := config.compile(); err != nil {
+			t.Fatalf("Error should be nil for %s: got %v", test.config, err)
+		}
+		got, err := config.target(server, "Dev")
+		if err != nil {
+			t.Fatalf("Error should be nil for %s: got %v", test.config, err)
+		}
+		if got != test.want {
+			t.Errorf("Wrong target for %s: got %v, want %v", test.config, got, test.want)
+		}
+	}
+}
+
+func TestServiceDiscoveryConfig_Compile_Errors(t *testing.T) {
+	for _, config := range []ServiceDiscoveryConfig{{Address: "{{.Name"}, {Port: 70000}} {
+		if err := config.compile(); err == nil {
+			t.Errorf("An error should be raised for %+v", config)
+		}
+	}
+}
+
+func TestDiscoverTargets(t *testing.T) {
+	api := MockPAExternalAPI{}
+	api.On("GetGroupList").Return(&GroupList{Groups: []Group{{ID: "193", Path: "Servers/Devices^Live^FX"}}}, nil)
+	api.On("GetServerList", "193").Return(&ServerList{Servers: []Server{{ID: "568", Name: "FXH1"}, {ID: "709", Name: "FXH2"}}}, nil)
+
+	config := Config{
+		Groups:           []GroupFilter{{GroupPath: "Servers/Devices^Live^FX"}, {GroupPath: "NOFX"}},
+		ServiceDiscovery: ServiceDiscoveryConfig{Port: 9182, Groups: []GroupFilter{{GroupPath: "Servers/Devices^Live^FX", Servers: []string{"FXH2"}}}},
+		InstanceName:     "prod",
+	}
+	targetGroups, err := discoverTargets(&api, config)
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	if len(targetGroups) != 1 {
+		t.Fatalf("Wrong number of target groups: got %v, want %v", len(targetGroups), 1)
+	}
+	if targetGroups[0].Targets[0] != "FXH2:9182" {
+		t.Errorf("Wrong target: got %v, want %v", targetGroups[0].Targets[0], "FXH2:9182")
+	}
+	wantLabels := map[string]string{
+		"__meta_poweradmin_group_path":   "Servers/Devices^Live^FX",
+		"__meta_poweradmin_group_id":     "193",
+		"__meta_poweradmin_server_id":    "709",
+		"__meta_poweradmin_server_name":  "FXH2",
+		"__meta_poweradmin_server_alias": "",
+		"__meta_poweradmin_instance":     "prod",
+	}
+	for name, want := range wantLabels {
+		if got, exists := targetGroups[0].Labels[name]; !exists || got != want {
+			t.Errorf("Wrong value for label %s: got %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestReloader_SDHandler(t *testing.T) {
+	resourcesHandler := func(w http.ResponseWriter, r *http.Request) {
+		apiParam := r.URL.Query()["API"][0]
+		if apiParam == "GET_GROUP_LIST" {
+			_, _ = w.Write([]byte(groupListString))
+		} else if apiParam == "GET_SERVER_LIST" {
+			_, _ = w.Write([]byte(serverListString))
+		}
+	}
+	ts := httptest.NewServer(http.HandlerFunc(resourcesHandler))
+	defer ts.Close()
+	dir := writeConfigFiles(t, map[string]string{
+		"config.yml": "server: \"" + ts.URL + "\"\napi_key: \"1234\"\ngroup:\n  - path: \"Servers/Devices^Live^FX\"\nservice_discovery:\n  port: 9182\n",
+	})
+	defer os.RemoveAll(dir)
+	reloader, err := NewReloader(dir)
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	reloader.SDHandler(recorder, httptest.NewRequest(http.MethodGet, "/sd", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Wrong status: got %v, want %v", recorder.Code, http.StatusOK)
+	}
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("Wrong content type: got %v", recorder.Header().Get("Content-Type"))
+	}
+	targetGroups := make([]TargetGroup, 0)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &targetGroups); err != nil {
+		t.Fatalf("Error decoding the response: %v", err)
+	}
+	if len(targetGroups) != 3 || targetGroups[0].Targets[0] != "FXH1:9182" {
+		t.Errorf("Wrong targets: got %+v", targetGroups)
+	}
+
+	recorder = httptest.NewRecorder()
+	reloader.SDHandler(recorder, httptest.NewRequest(http.MethodGet, "/sd?instance=dr", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Wrong status for an unknown instance: got %v, want %v", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestReloader_SDHandler_FailingInstance(t *testing.T) {
+	api := MockPAExternalAPI{}
+	api.On("GetGroupList").Return(&GroupList{Groups: []Group{{ID: "1", Path: "Dev"}}}, nil)
+	api.On("GetServerList", "1").Return(&ServerList{Servers: []Server{{ID: "2", Name: "FXH1"}}}, nil)
+	failing := MockPAExternalAPI{}
+	failing.On("GetGroupList").Return((*GroupList)(nil), errors.New("PowerAdmin is down"))
+	groups := []GroupFilter{{GroupPath: "Dev"}}
+	reloader := &Reloader{collectors: []*Collector{
+		NewCollector(&api, Config{InstanceName: "prod", Groups: groups}),
+		NewCollector(&failing, Config{InstanceName: "dr", Groups: groups}),
+	}}
+	errorsBefore := readCounter(sdErrorsTotal, "dr")
+
+	recorder := httptest.NewRecorder()
+	reloader.SDHandler(recorder, httptest.NewRequest(http.MethodGet, "/sd", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Wrong status: got %v, want %v", recorder.Code, http.StatusOK)
+	}
+	targetGroups := make([]TargetGroup, 0)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &targetGroups); err != nil {
+		t.Fatalf("Error decoding the response: %v", err)
+	}
+	if len(targetGroups) != 1 || targetGroups[0].Labels[metaLabelPrefix+"instance"] != "prod" {
+		t.Errorf("The targets of the other instances should be served: got %+v", targetGroups)
+	}
+	if got := readCounter(sdErrorsTotal, "dr"); got != errorsBefore+1 {
+		t.Errorf("Wrong number of service discovery errors: got %v, want %v", got, errorsBefore+1)
+	}
+
+	recorder = httptest.NewRecorder()
+	reloader.SDHandler(recorder, httptest.NewRequest(http.MethodGet, "/sd?instance=dr", nil))
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("Wrong status when all the instances fail: got %v, want %v", recorder.Code, http.StatusInternalServerError)
+	}
+}
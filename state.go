@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/prometheus/common/log"
+)
+
+const (
+	stateFileVersion         = 1
+	defaultStateSaveInterval = time.Minute
+)
+
+// stateFile the content of the state file, Version is increased when the format changes
+type stateFile struct {
+	Version   int                                 `json:"version"`
+	Instances map[string]map[string]*MonitorState `json:"instances"`
+}
+
+// save writes the monitor states to the file
+func (t *StatusTracker) save(fileName string) error {
+	t.mutex.Lock()
+	data, err := json.Marshal(stateFile{Version: stateFileVersion, Instances: t.states})
+	t.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fileName, data)
+}
+
+// load replaces the monitor states with the ones of the file, a missing file is not an error.
+// The monitors are seen at now so that the time the exporter was stopped is not counted in their status.
+func (t *StatusTracker) load(fileName string, now time.Time) error {
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	state := stateFile{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("%s: %v", fileName, err)
+	}
+	if state.Version != stateFileVersion {
+		return fmt.Errorf("%s: unsupported state file version %d, expected %d", fileName, state.Version, stateFileVersion)
+	}
+	for _, states := range state.Instances {
+		for _, monitorState := range states {
+			if monitorState.Seconds == nil {
+				monitorState.Seconds = make(map[string]float64)
+			}
+			monitorState.LastSeen = now
+		}
+	}
+	if state.Instances == nil {
+		state.Instances = make(map[string]map[string]*MonitorState)
+	}
+	t.mutex.Lock()
+	t.states = state.Instances
+	t.mutex.Unlock()
+	return nil
+}
+
+// LoadState restores the monitor states from the state file when one is configured
+func (r *Reloader) LoadState() error {
+	fileName := r.Config().StatusTracking.StateFile
+	if fileName == "" {
+		return nil
+	}
+	if err := r.tracker.load(fileName, time.Now()); err != nil {
+		return err
+	}
+	log.Infof("Loaded the monitor states from %s", fileName)
+	return nil
+}
+
+// SaveState writes the monitor states to the state file when one is configured
+func (r *Reloader) SaveState() error {
+	fileName := r.Config().StatusTracking.StateFile
+	if fileName == "" {
+		return nil
+	}
+	return r.tracker.save(fileName)
+}
+
+// RunStateSaver saves the monitor states periodically, the interval being read from the current config
+func (r *Reloader) RunStateSaver() {
+	for {
+		interval := r.Config().StatusTracking.SaveInterval
+		if interval <= 0 {
+			interval = defaultStateSaveInterval
+		}
+		time.Sleep(interval)
+		if err := r.SaveState(); err != nil {
+			log.Errorf("Error saving the monitor states: %v", err)
+		}
+	}
+}
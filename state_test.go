@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusTracker_SaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatalf("Error creating the folder: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "state.json")
+
+	tracker := NewStatusTracker()
+	if err := tracker.load(fileName, time.Now()); err != nil {
+		t.Fatalf("A missing state file should not raise an error: got %v", err)
+	}
+	start := time.Unix(1000, 0)
+	value := MonitoredValue{MonitorID: "8937", MonitorTitle: "Ping", MonitorStatus: "OK", ServerName: "FXH1", GroupPath: "Dev"}
+	tracker.update("prod", []MonitoredValue{value}, start)
+	value.MonitorStatus = "Alert"
+	tracker.update("prod", []MonitoredValue{value}, start.Add(time.Minute))
+	if err := tracker.save(fileName); err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+
+	// the exporter is stopped for 30 seconds
+	restored := NewStatusTracker()
+	if err := restored.load(fileName, start.Add(90*time.Second)); err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	state, exists := restored.states["prod"]["8937"]
+	if !exists {
+		t.Fatalf("The monitor state should be restored: got %v", restored.states)
+	}
+	if state.Status != "alert" || state.Changes != 1 || state.Seconds["ok"] != 60 || !state.Since.Equal(start.Add(time.Minute)) {
+		t.Errorf("Wrong restored state: got %+v", state)
+	}
+
+	value.MonitorStatus = "OK"
+	restored.update("prod", []MonitoredValue{value}, start.Add(2*time.Minute))
+	if state.Changes != 2 || state.Seconds["alert"] != 30 {
+		t.Errorf("The restored state should be updated without the time the exporter was stopped: got %+v", state)
+	}
+}
+
+func TestStatusTracker_Load_Errors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatalf("Error creating the folder: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := map[string]string{
+		`{"version": 2, "instances": {}}`: "unsupported state file version 2",
+		`{"version": `:                    "unexpected end of JSON input",
+	}
+	for content, want := range tests {
+		fileName := filepath.Join(dir, "state.json")
+		if err := ioutil.WriteFile(fileName, []byte(content), 0600); err != nil {
+			t.Fatalf("Error writing the state file: %v", err)
+		}
+		tracker := NewStatusTracker()
+		if err := tracker.load(fileName, time.Now()); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Wrong error for %s: got %v, want %v", content, err, want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// paStatusCodes values returned by PowerAdmin for the status of the monitors
+var paStatusCodes = map[string]float64{
+	"alert":                               2,
+	"alert - skipping actions":            10,
+	"alert - green":                       17,
+	"alert - red":                         18,
+	"alert - suppressing":                 19,
+	"bad license":                         14,
+	"can't run":                           4,
+	"dependency not met":                  16,
+	"disabled":                            6,
+	"error":                               3,
+	"error - suppressed":                  21,
+	"ok":                                  1,
+	"ok - unacknowledged alerts - yellow": 20,
+	"ok - unacknowledged alerts - red":    24,
+	"ok - unacknowledged alerts - green":  25,
+	"monitor busy":                        11,
+	"monitor maintenance mode":            13,
+	"satellite disconnected":              23,
+	"scheduled":                           7,
+	"server disabled":                     22,
+	"server maintenance mode":             26,
+	"startup pause":                       8,
+	"training":                            12,
+	"unlicensed":                          9,
+}
+
+// StatusRule maps the statuses matching a regex or starting with a prefix to a value
+type StatusRule struct {
+	Regex  string  `yaml:"regex"`
+	Prefix string  `yaml:"prefix"`
+	Value  float64 `yaml:"value"`
+	regex  *regexp.Regexp
+}
+
+// StatusOverride status mapping for the monitors with a given title and/or group path
+type StatusOverride struct {
+	Title     string             `yaml:"title"`
+	GroupPath string             `yaml:"group"`
+	Statuses  map[string]float64 `yaml:"values"`
+	Rules     []StatusRule       `yaml:"rules"`
+	Default   *float64           `yaml:"default"`
+}
+
+// compile compiles the regexes of the rules, the rules of the overrides included
+func (s *StatusConfig) compile() error {
+	for i := range s.Rules {
+		if err := s.Rules[i].compile(); err != nil {
+			return err
+		}
+	}
+	for i := range s.Overrides {
+		for j := range s.Overrides[i].Rules {
+			if err := s.Overrides[i].Rules[j].compile(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *StatusRule) compile() error {
+	if (r.Regex == "") == (r.Prefix == "") {
+		return errors.New("a status rule needs either a regex or a prefix")
+	}
+	if r.Regex != "" {
+		// the statuses are lowercased, the regex must not depend on the case
+		regex, err := regexp.Compile("(?i)" + r.Regex)
+		if err != nil {
+			return fmt.Errorf("invalid status rule regex %q: %v", r.Regex, err)
+		}
+		r.regex = regex
+	}
+	return nil
+}
+
+func (r *StatusRule) matches(status string) bool {
+	if r.Prefix != "" {
+		return strings.HasPrefix(status, strings.ToLower(r.Prefix))
+	}
+	return r.regex.MatchString(status)
+}
+
+func (o *StatusOverride) appliesTo(metric MonitoredValue) bool {
+	if o.Title != "" && o.Title != metric.MonitorTitle {
+		return false
+	}
+	if o.GroupPath != "" && o.GroupPath != metric.GroupPath {
+		return false
+	}
+	return true
+}
+
+// mapStatus returns the value of a status for a monitor and whether a mapping was found for it
+func (s *StatusConfig) mapStatus(metric MonitoredValue) (float64, bool) {
+	status := strings.ToLower(metric.MonitorValue)
+	for i := range s.Overrides {
+		override := &s.Overrides[i]
+		if !override.appliesTo(metric) {
+			continue
+		}
+		if value, found := lookupStatus(status, override.Statuses, override.Rules); found {
+			return value, true
+		}
+		if override.Default != nil {
+			return *override.Default, true
+		}
+	}
+	if value, found := lookupStatus(status, s.Statuses, s.Rules); found {
+		return value, true
+	}
+	if s.UseStatusCodes {
+		if code, found := paStatusCodes[status]; found {
+			return code, true
+		}
+		if code, err := strconv.ParseFloat(status, 64); err == nil {
+			return code, true
+		}
+	}
+	return s.Default, false
+}
+
+func lookupStatus(status string, statuses map[string]float64, rules []StatusRule) (float64, bool) {
+	if value, found := statuses[status]; found {
+		return value, true
+	}
+	for i := range rules {
+		if rules[i].matches(status) {
+			return rules[i].Value, true
+		}
+	}
+	return 0, false
+}
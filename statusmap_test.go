@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+const statusMappingString = `
+values:
+  "ok": 1
+default: 0
+rules:
+  - prefix: "Alert -"
+    value: 2
+  - regex: "^error"
+    value: 3
+overrides:
+  - title: "Ping FXMACHINE1"
+    values:
+      "ok": 10
+  - group: "Servers/Devices^Live^Dev"
+    default: -1
+`
+
+func TestStatusConfig_MapStatus(t *testing.T) {
+	statusConfig := StatusConfig{}
+	if err := yaml.Unmarshal([]byte(statusMappingString), &statusConfig); err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	if err := statusConfig.compile(); err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	tests := []struct {
+		metric MonitoredValue
+		want   float64
+		mapped bool
+	}{
+		{MonitoredValue{MonitorValue: "OK"}, 1, true},
+		{MonitoredValue{MonitorValue: "Alert - Red"}, 2, true},
+		{MonitoredValue{MonitorValue: "Error - Suppressed"}, 3, true},
+		{MonitoredValue{MonitorValue: "Training"}, 0, false},
+		{MonitoredValue{MonitorValue: "OK", MonitorTitle: "Ping FXMACHINE1"}, 10, true},
+		{MonitoredValue{MonitorValue: "Alert", MonitorTitle: "Ping FXMACHINE1"}, 0, false},
+		{MonitoredValue{MonitorValue: "Training", GroupPath: "Servers/Devices^Live^Dev"}, -1, true},
+	}
+	for _, test := range tests {
+		got, mapped := statusConfig.mapStatus(test.metric)
+		if got != test.want || mapped != test.mapped {
+			t.Errorf("Wrong value for %q: got %v (%v), want %v (%v)", test.metric.MonitorValue, got, mapped, test.want, test.mapped)
+		}
+	}
+}
+
+func TestStatusConfig_MapStatus_StatusCodes(t *testing.T) {
+	statusConfig := StatusConfig{Default: -1, UseStatusCodes: true}
+	tests := map[string]float64{
+		"OK":                                 1,
+		"Alert - Skipping Actions":           10,
+		"OK - Unacknowledged Alerts - Green": 25,
+		"12":                                 12,
+		"Unknown":                            -1,
+	}
+	for status, want := range tests {
+		got, _ := statusConfig.mapStatus(MonitoredValue{MonitorValue: status})
+		if got != want {
+			t.Errorf("Wrong value for %q: got %v, want %v", status, got, want)
+		}
+	}
+}
+
+func TestStatusRule_Matches_CaseInsensitive(t *testing.T) {
+	for _, rule := range []StatusRule{{Regex: "^Alert - "}, {Regex: "^alert - "}, {Prefix: "ALERT - "}} {
+		if err := rule.compile(); err != nil {
+			t.Fatalf("Error should be nil: got %v", err)
+		}
+		statusConfig := StatusConfig{Rules: []StatusRule{rule}}
+		statusConfig.Rules[0].Value = 2
+		if got, mapped := statusConfig.mapStatus(MonitoredValue{MonitorValue: "Alert - Red"}); got != 2 || !mapped {
+			t.Errorf("The rule %+v should match whatever the case: got %v (%v)", rule, got, mapped)
+		}
+	}
+}
+
+func TestStatusRule_Compile_Errors(t *testing.T) {
+	invalid := []string{
+		`{regex: "(", value: 1}`,
+		`{value: 1}`,
+		`{regex: "^ok", prefix: "ok", value: 1}`,
+	}
+	for _, rule := range invalid {
+		statusRule := StatusRule{}
+		if err := yaml.Unmarshal([]byte(rule), &statusRule); err != nil {
+			t.Fatal(err)
+		}
+		if err := statusRule.compile(); err == nil {
+			t.Errorf("Rule %s should raise an error", rule)
+		}
+	}
+}
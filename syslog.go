@@ -0,0 +1,562 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+const (
+	defaultSyslogStatusTTL = 5 * time.Minute
+	maxSyslogMessageSize   = 64 * 1024
+	maxSyslogConnections   = 100
+	syslogIdleTimeout      = 5 * time.Minute
+	maxOverlayStatuses     = 10000
+	syslogTimestamp3164    = "Jan _2 15:04:05"
+)
+
+var (
+	syslogMessagesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "poweradmin_syslog_messages_total",
+		Help: "Number of syslog messages received, by result: matched, unmatched, invalid or rejected",
+	}, []string{"result"})
+	syslogAlertsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "poweradmin_syslog_alerts_total",
+		Help: "Number of PowerAdmin alert messages received by syslog for the collected monitors",
+	}, []string{"server_name", "monitor", "status"})
+	defaultSyslogPatterns = []SyslogPattern{
+		newSyslogPattern(`(?i)monitor (?P<monitor>.+?) on (?P<server>\S+) (?:is|changed to) (?P<status>[\w' -]+?)(?:: (?P<err_text>.*))?$`),
+	}
+)
+
+// SyslogConfig the syslog listener receiving the PowerAdmin alert messages, enabled when an address is set.
+// The listen addresses are read at startup only.
+type SyslogConfig struct {
+	ListenUDP string `yaml:"listen_udp"`
+	ListenTCP string `yaml:"listen_tcp"`
+	// AllowedSources the networks allowed to send messages, all when empty
+	AllowedSources  []string `yaml:"allowed_sources"`
+	allowedNetworks []*net.IPNet
+	// Instance the instance the monitors of the messages belong to
+	Instance string          `yaml:"instance"`
+	Patterns []SyslogPattern `yaml:"patterns"`
+	// StatusTTL how long a received status replaces the status collected from PowerAdmin
+	StatusTTL time.Duration `yaml:"status_ttl"`
+}
+
+// compile parses the allowed sources and compiles the patterns
+func (c *SyslogConfig) compile() error {
+	networks, err := parseNetworks(c.AllowedSources)
+	if err != nil {
+		return err
+	}
+	c.allowedNetworks = networks
+	for i := range c.Patterns {
+		if err := c.Patterns[i].compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseNetworks parses CIDRs, an IP address being a network of one address
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if ip := net.ParseIP(cidr); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid syslog allowed source %q", cidr)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// allowed tells if the source address may send messages
+func (c *SyslogConfig) allowed(source net.Addr) bool {
+	if len(c.AllowedSources) == 0 {
+		return true
+	}
+	var ip net.IP
+	switch addr := source.(type) {
+	case *net.UDPAddr:
+		ip = addr.IP
+	case *net.TCPAddr:
+		ip = addr.IP
+	}
+	for _, network := range c.allowedNetworks {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// SyslogPattern a regex extracting the monitor fields from a message with the named groups
+// server, monitor, status, group and err_text. The server is the syslog host name when there is no server group.
+type SyslogPattern struct {
+	Regex string `yaml:"regex"`
+	regex *regexp.Regexp
+}
+
+func newSyslogPattern(regex string) SyslogPattern {
+	return SyslogPattern{Regex: regex, regex: regexp.MustCompile(regex)}
+}
+
+// compile compiles the regex and checks that it has the monitor and status groups
+func (p *SyslogPattern) compile() error {
+	regex, err := regexp.Compile(p.Regex)
+	if err != nil {
+		return fmt.Errorf("invalid syslog pattern %q: %v", p.Regex, err)
+	}
+	names := make(map[string]struct{})
+	for _, name := range regex.SubexpNames() {
+		names[name] = struct{}{}
+	}
+	for _, required := range []string{"monitor", "status"} {
+		if _, exists := names[required]; !exists {
+			return fmt.Errorf("the syslog pattern %q must have a %s group", p.Regex, required)
+		}
+	}
+	p.regex = regex
+	return nil
+}
+
+// match returns the monitor value extracted from the message, false when no pattern matches
+func (c *SyslogConfig) match(message syslogMessage) (MonitoredValue, bool) {
+	patterns := c.Patterns
+	if len(patterns) == 0 {
+		patterns = defaultSyslogPatterns
+	}
+	for _, pattern := range patterns {
+		regex := pattern.regex
+		groups := regex.FindStringSubmatch(message.Message)
+		if groups == nil {
+			continue
+		}
+		value := MonitoredValue{ServerName: message.Hostname, MonitorLastRun: message.Timestamp}
+		for i, name := range regex.SubexpNames() {
+			switch name {
+			case "server":
+				value.ServerName = groups[i]
+			case "monitor":
+				value.MonitorTitle = groups[i]
+			case "status":
+				value.MonitorStatus = groups[i]
+				value.MonitorValue = groups[i]
+			case "group":
+				value.GroupPath = groups[i]
+			case "err_text":
+				value.MonitorErrText = groups[i]
+			}
+		}
+		if value.ServerName == "" || value.MonitorTitle == "" || value.MonitorStatus == "" {
+			continue
+		}
+		return value, true
+	}
+	return MonitoredValue{}, false
+}
+
+// syslogMessage the fields of a syslog message used by the exporter
+type syslogMessage struct {
+	Timestamp time.Time
+	Hostname  string
+	AppName   string
+	Message   string
+}
+
+// parseSyslog parses an RFC 5424 or RFC 3164 message, the missing timestamp is set to now
+func parseSyslog(line string, now time.Time) (syslogMessage, error) {
+	line = strings.TrimRight(line, "\r\n\x00")
+	end := strings.IndexByte(line, '>')
+	if !strings.HasPrefix(line, "<") || end < 2 || end > 4 {
+		return syslogMessage{}, errors.New("missing syslog priority")
+	}
+	if _, err := strconv.Atoi(line[1:end]); err != nil {
+		return syslogMessage{}, fmt.Errorf("invalid syslog priority %q", line[1:end])
+	}
+	rest := line[end+1:]
+	var message syslogMessage
+	var err error
+	if strings.HasPrefix(rest, "1 ") {
+		message, err = parseSyslog5424(rest[2:])
+	} else {
+		message = parseSyslog3164(rest, now)
+	}
+	if message.Timestamp.IsZero() {
+		message.Timestamp = now
+	}
+	return message, err
+}
+
+func parseSyslog5424(rest string) (syslogMessage, error) {
+	fields := strings.SplitN(rest, " ", 6)
+	if len(fields) < 6 {
+		return syslogMessage{}, errors.New("incomplete RFC 5424 header")
+	}
+	message := syslogMessage{Hostname: nilValue(fields[1]), AppName: nilValue(fields[2])}
+	if fields[0] != "-" {
+		timestamp, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return syslogMessage{}, fmt.Errorf("invalid RFC 5424 timestamp %q", fields[0])
+		}
+		message.Timestamp = timestamp
+	}
+	msg, err := skipStructuredData(fields[5])
+	if err != nil {
+		return syslogMessage{}, err
+	}
+	message.Message = strings.TrimPrefix(msg, "\xEF\xBB\xBF")
+	return message, nil
+}
+
+// skipStructuredData returns the message following the structured data of an RFC 5424 message
+func skipStructuredData(rest string) (string, error) {
+	if strings.HasPrefix(rest, "-") {
+		return strings.TrimPrefix(rest[1:], " "), nil
+	}
+	i := 0
+	for i < len(rest) && rest[i] == '[' {
+		escaped := false
+		closed := false
+		for i++; i < len(rest); i++ {
+			if escaped {
+				escaped = false
+			} else if rest[i] == '\\' {
+				escaped = true
+			} else if rest[i] == ']' {
+				closed = true
+				i++
+				break
+			}
+		}
+		if !closed {
+			return "", errors.New("unterminated RFC 5424 structured data")
+		}
+	}
+	if i == 0 {
+		return "", errors.New("invalid RFC 5424 structured data")
+	}
+	return strings.TrimPrefix(rest[i:], " "), nil
+}
+
+func nilValue(field string) string {
+	if field == "-" {
+		return ""
+	}
+	return field
+}
+
+// parseSyslog3164 parses the "Mmm dd hh:mm:ss HOSTNAME TAG: MSG" part, the whole part being the message when there is no timestamp
+func parseSyslog3164(rest string, now time.Time) syslogMessage {
+	if len(rest) < len(syslogTimestamp3164)+1 {
+		return syslogMessage{Message: rest}
+	}
+	timestamp, err := time.ParseInLocation(syslogTimestamp3164, rest[:len(syslogTimestamp3164)], now.Location())
+	if err != nil {
+		return syslogMessage{Message: rest}
+	}
+	message := syslogMessage{Timestamp: timestamp.AddDate(now.Year(), 0, 0)}
+	if message.Timestamp.After(now.AddDate(0, 1, 0)) {
+		// message of the previous year received in January
+		message.Timestamp = message.Timestamp.AddDate(-1, 0, 0)
+	}
+	fields := strings.SplitN(strings.TrimPrefix(rest[len(syslogTimestamp3164):], " "), " ", 2)
+	message.Hostname = fields[0]
+	if len(fields) == 1 {
+		return message
+	}
+	message.Message = fields[1]
+	if colon := strings.Index(fields[1], ": "); colon > 0 && !strings.ContainsAny(fields[1][:colon], " ") {
+		tag := fields[1][:colon]
+		if bracket := strings.IndexByte(tag, '['); bracket > 0 {
+			tag = tag[:bracket]
+		}
+		message.AppName = tag
+		message.Message = fields[1][colon+2:]
+	}
+	return message
+}
+
+// StatusOverlay the statuses received by syslog or the webhook between two scrapes, they replace the collected statuses until they expire
+type StatusOverlay struct {
+	mutex sync.Mutex
+	// statuses by instance name and server name and monitor title
+	statuses map[string]map[string]overlayStatus
+	// monitors the keys of the monitors of the last collection of each instance, only their statuses are kept
+	monitors map[string]map[string]struct{}
+}
+
+type overlayStatus struct {
+	value    MonitoredValue
+	received time.Time
+}
+
+// NewStatusOverlay returns an empty overlay
+func NewStatusOverlay() *StatusOverlay {
+	return &StatusOverlay{
+		statuses: make(map[string]map[string]overlayStatus),
+		monitors: make(map[string]map[string]struct{}),
+	}
+}
+
+// overlayKey returns the key of a received status, the monitor ID when known
+func overlayKey(value MonitoredValue) string {
+	if value.MonitorID != "" {
+		return "id:" + value.MonitorID
+	}
+	return "name:" + value.ServerName + "/" + value.MonitorTitle
+}
+
+// set records a received status of a monitor, it returns false and ignores the status when the monitor was not in
+// the last collection of the instance so that a sender can't add monitors
+func (o *StatusOverlay) set(instance string, value MonitoredValue, now time.Time) bool {
+	if o == nil {
+		return false
+	}
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if _, known := o.monitors[instance][overlayKey(value)]; !known {
+		return false
+	}
+	if o.statuses[instance] == nil {
+		o.statuses[instance] = make(map[string]overlayStatus)
+	}
+	key := overlayKey(value)
+	if _, exists := o.statuses[instance][key]; !exists && len(o.statuses[instance]) >= maxOverlayStatuses {
+		log.Warnf("Too many received statuses, ignoring the status of %s on %s", value.MonitorTitle, value.ServerName)
+		return true
+	}
+	o.statuses[instance][key] = overlayStatus{value: value, received: now}
+	return true
+}
+
+// merge replaces the status of the collected values with the received statuses younger than ttl and than the last run
+// of the monitor, the other ones are removed. The collected monitors are the ones whose statuses are accepted next.
+func (o *StatusOverlay) merge(instance string, values []MonitoredValue, ttl time.Duration, now time.Time) []MonitoredValue {
+	if o == nil {
+		return values
+	}
+	if ttl <= 0 {
+		ttl = defaultSyslogStatusTTL
+	}
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	monitors := make(map[string]struct{}, 2*len(values))
+	for _, value := range values {
+		monitors[overlayKey(value)] = struct{}{}
+		monitors[overlayKey(MonitoredValue{ServerName: value.ServerName, MonitorTitle: value.MonitorTitle})] = struct{}{}
+	}
+	o.monitors[instance] = monitors
+	statuses := o.statuses[instance]
+	for key, status := range statuses {
+		if now.Sub(status.received) >= ttl {
+			delete(statuses, key)
+		}
+	}
+	if len(statuses) == 0 {
+		return values
+	}
+	merged := make([]MonitoredValue, len(values))
+	for i, value := range values {
+		key := overlayKey(value)
+		status, exists := statuses[key]
+		if !exists {
+			key = overlayKey(MonitoredValue{ServerName: value.ServerName, MonitorTitle: value.MonitorTitle})
+			status, exists = statuses[key]
+		}
+		if exists && value.MonitorLastRun.After(status.value.MonitorLastRun) {
+			// the monitor ran again since the message
+			delete(statuses, key)
+			exists = false
+		}
+		if exists {
+			value.MonitorStatus = status.value.MonitorStatus
+			value.MonitorValue = status.value.MonitorValue
+			value.MonitorErrText = status.value.MonitorErrText
+			value.MonitorLastRun = status.value.MonitorLastRun
+		}
+		merged[i] = value
+	}
+	return merged
+}
+
+// handleSyslog processes one syslog message
+func (r *Reloader) handleSyslog(line string, now time.Time) {
+	fullConfig := r.Config()
+	config := fullConfig.Syslog
+	// no instance is the first one, its name is the one of the collector
+	instance, err := fullConfig.instanceConfig(config.Instance)
+	if err != nil {
+		log.Errorf("Error handling the syslog message: %v", err)
+		return
+	}
+	message, err := parseSyslog(line, now)
+	if err != nil {
+		log.Debugf("Invalid syslog message %q: %v", line, err)
+		syslogMessagesTotal.WithLabelValues("invalid").Inc()
+		return
+	}
+	value, matched := config.match(message)
+	if !matched {
+		syslogMessagesTotal.WithLabelValues("unmatched").Inc()
+		return
+	}
+	syslogMessagesTotal.WithLabelValues("matched").Inc()
+	if r.applyMonitorStatus(instance.InstanceName, value) {
+		// the labels of the monitors not collected are not kept so that a sender can't create series without bound
+		syslogAlertsTotal.WithLabelValues(value.ServerName, value.MonitorTitle, strings.ToLower(value.MonitorStatus)).Inc()
+	}
+}
+
+// ListenSyslog starts the UDP and TCP syslog listeners of the config, it returns when they are listening
+func (r *Reloader) ListenSyslog() error {
+	config := r.Config().Syslog
+	if config.ListenUDP != "" {
+		conn, err := net.ListenPacket("udp", config.ListenUDP)
+		if err != nil {
+			return fmt.Errorf("error listening for syslog on UDP %s: %v", config.ListenUDP, err)
+		}
+		log.Infof("Listening for syslog messages on UDP %s", conn.LocalAddr())
+		go r.serveSyslogUDP(conn)
+	}
+	if config.ListenTCP != "" {
+		listener, err := net.Listen("tcp", config.ListenTCP)
+		if err != nil {
+			return fmt.Errorf("error listening for syslog on TCP %s: %v", config.ListenTCP, err)
+		}
+		log.Infof("Listening for syslog messages on TCP %s", listener.Addr())
+		go r.serveSyslogTCP(listener)
+	}
+	return nil
+}
+
+func (r *Reloader) serveSyslogUDP(conn net.PacketConn) {
+	buffer := make([]byte, maxSyslogMessageSize)
+	for {
+		n, source, err := conn.ReadFrom(buffer)
+		if err != nil {
+			log.Errorf("Error reading syslog messages: %v", err)
+			return
+		}
+		if config := r.Config().Syslog; !config.allowed(source) {
+			log.Debugf("Rejected the syslog message of %s which is not an allowed source", source)
+			syslogMessagesTotal.WithLabelValues("rejected").Inc()
+			continue
+		}
+		r.handleSyslog(string(buffer[:n]), time.Now())
+	}
+}
+
+// serveSyslogTCP serves the TCP connections, at most maxSyslogConnections at a time, closing the idle ones
+func (r *Reloader) serveSyslogTCP(listener net.Listener) {
+	connections := make(chan struct{}, maxSyslogConnections)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Errorf("Error accepting syslog connections: %v", err)
+			return
+		}
+		if config := r.Config().Syslog; !config.allowed(conn.RemoteAddr()) {
+			log.Debugf("Rejected the syslog connection of %s which is not an allowed source", conn.RemoteAddr())
+			syslogMessagesTotal.WithLabelValues("rejected").Inc()
+			conn.Close()
+			continue
+		}
+		select {
+		case connections <- struct{}{}:
+		default:
+			log.Warnf("Too many syslog connections, closing the connection of %s", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer func() { <-connections }()
+			defer conn.Close()
+			reader := newSyslogReader(conn)
+			for {
+				if err := conn.SetReadDeadline(time.Now().Add(syslogIdleTimeout)); err != nil {
+					return
+				}
+				line, err := readSyslogFrame(reader)
+				if line != "" {
+					r.handleSyslog(line, time.Now())
+				}
+				if err == errSyslogFrameTooLong {
+					syslogMessagesTotal.WithLabelValues("invalid").Inc()
+					continue
+				}
+				if err != nil {
+					if err != io.EOF {
+						log.Debugf("Closing the syslog connection of %s: %v", conn.RemoteAddr(), err)
+					}
+					return
+				}
+			}
+		}()
+	}
+}
+
+var errSyslogFrameTooLong = fmt.Errorf("syslog message longer than %d bytes", maxSyslogMessageSize)
+
+// newSyslogReader returns a reader whose buffer holds the longest syslog message accepted
+func newSyslogReader(conn io.Reader) *bufio.Reader {
+	return bufio.NewReaderSize(conn, maxSyslogMessageSize)
+}
+
+// readSyslogFrame reads a TCP syslog message framed by octet counting or by a new line (RFC 6587).
+// A message framed by a new line longer than the reader buffer is skipped with errSyslogFrameTooLong.
+func readSyslogFrame(reader *bufio.Reader) (string, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return "", err
+	}
+	if first[0] < '0' || first[0] > '9' {
+		line, err := reader.ReadSlice('\n')
+		if err != bufio.ErrBufferFull {
+			return string(line), err
+		}
+		for err == bufio.ErrBufferFull {
+			_, err = reader.ReadSlice('\n')
+		}
+		if err != nil {
+			return "", err
+		}
+		return "", errSyslogFrameTooLong
+	}
+	lengthField, err := reader.ReadSlice(' ')
+	if err == bufio.ErrBufferFull {
+		return "", errors.New("invalid syslog frame length")
+	}
+	if err != nil {
+		return "", err
+	}
+	length, err := strconv.Atoi(strings.TrimSuffix(string(lengthField), " "))
+	if err != nil || length <= 0 || length > maxSyslogMessageSize {
+		return "", fmt.Errorf("invalid syslog frame length %q", lengthField)
+	}
+	frame := make([]byte, length)
+	if _, err := io.ReadFull(reader, frame); err != nil {
+		return "", err
+	}
+	return string(frame), nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestParseSyslog(t *testing.T) {
+	now := time.Date(2019, time.March, 4, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		line    string
+		want    syslogMessage
+		wantErr bool
+	}{
+		{
+			name: "RFC 3164",
+			line: "<13>Mar  4 09:58:12 FXH1 PowerAdmin[1234]: Monitor Ping on FXH1 is Alert\n",
+			want: syslogMessage{Timestamp: time.Date(2019, time.March, 4, 9, 58, 12, 0, time.UTC), Hostname: "FXH1", AppName: "PowerAdmin", Message: "Monitor Ping on FXH1 is Alert"},
+		},
+		{
+			name: "RFC 3164 of the previous year",
+			line: "<13>Dec 31 23:59:59 FXH1 PowerAdmin: Monitor Ping on FXH1 is OK",
+			want: syslogMessage{Timestamp: time.Date(2018, time.December, 31, 23, 59, 59, 0, time.UTC), Hostname: "FXH1", AppName: "PowerAdmin", Message: "Monitor Ping on FXH1 is OK"},
+		},
+		{
+			name: "RFC 3164 without header",
+			line: "<13>Monitor Ping on FXH1 is OK",
+			want: syslogMessage{Timestamp: now, Message: "Monitor Ping on FXH1 is OK"},
+		},
+		{
+			name: "RFC 5424",
+			line: `<165>1 2019-03-04T09:58:12.5Z FXH1 PowerAdmin 1234 ID47 [origin ip="10.0.0.1"][meta x="a\]b"] ` + "\xEF\xBB\xBF" + "Monitor Ping on FXH1 is Alert",
+			want: syslogMessage{Timestamp: time.Date(2019, time.March, 4, 9, 58, 12, 500000000, time.UTC), Hostname: "FXH1", AppName: "PowerAdmin", Message: "Monitor Ping on FXH1 is Alert"},
+		},
+		{
+			name: "RFC 5424 with nil values",
+			line: "<165>1 - - - - - - Monitor Ping on FXH1 is OK",
+			want: syslogMessage{Timestamp: now, Message: "Monitor Ping on FXH1 is OK"},
+		},
+		{name: "no priority", line: "Monitor Ping on FXH1 is OK", wantErr: true},
+		{name: "invalid RFC 5424 timestamp", line: "<165>1 yesterday FXH1 - - - - message", wantErr: true},
+		{name: "unterminated structured data", line: "<165>1 - FXH1 - - - [origin message", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := parseSyslog(test.line, now)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Wrong error for %s: %v", test.name, err)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Wrong message for %s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSyslogConfig_Match(t *testing.T) {
+	config := SyslogConfig{}
+	if err := yaml.UnmarshalStrict([]byte(`patterns:
+  - regex: '^(?P<group>[^/]+)/(?P<monitor>[^:]+): (?P<status>\w+)$'
+`), &config); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.compile(); err != nil {
+		t.Fatal(err)
+	}
+	lastRun := time.Now()
+	value, matched := config.match(syslogMessage{Timestamp: lastRun, Hostname: "FXH1", Message: "Dev/Ping: Alert"})
+	want := MonitoredValue{GroupPath: "Dev", ServerName: "FXH1", MonitorTitle: "Ping", MonitorStatus: "Alert", MonitorValue: "Alert", MonitorLastRun: lastRun}
+	if !matched || !reflect.DeepEqual(value, want) {
+		t.Errorf("Wrong match: got %+v, %v, want %+v", value, matched, want)
+	}
+	if _, matched := config.match(syslogMessage{Message: "Dev/Ping: Alert"}); matched {
+		t.Error("A message without server should not match")
+	}
+
+	value, matched = (&SyslogConfig{}).match(syslogMessage{Hostname: "pa", Message: "Monitor Disk C: on FXH2 changed to Error: Low disk space"})
+	if !matched || value.ServerName != "FXH2" || value.MonitorTitle != "Disk C:" || value.MonitorStatus != "Error" || value.MonitorErrText != "Low disk space" {
+		t.Errorf("Wrong match of the default pattern: got %+v, %v", value, matched)
+	}
+}
+
+func TestSyslogPattern_Compile(t *testing.T) {
+	for _, regex := range []string{`(?P<monitor>.+`, `(?P<monitor>.+) is (.+)`} {
+		pattern := SyslogPattern{Regex: regex}
+		if err := pattern.compile(); err == nil {
+			t.Errorf("The pattern %s should be rejected", regex)
+		}
+	}
+}
+
+func TestStatusOverlay_Merge(t *testing.T) {
+	now := time.Now()
+	overlay := NewStatusOverlay()
+	values := []MonitoredValue{
+		{ServerName: "FXH1", MonitorTitle: "Ping", MonitorStatus: "OK", MonitorValue: "OK", MonitorLastRun: now.Add(-time.Minute)},
+		{ServerName: "FXH1", MonitorTitle: "Disk", MonitorStatus: "OK", MonitorValue: "OK", MonitorLastRun: now.Add(time.Minute)},
+		{ServerName: "FXH1", MonitorTitle: "CPU", MonitorStatus: "OK", MonitorValue: "OK", MonitorLastRun: now.Add(-time.Minute)},
+	}
+	if overlay.set("", MonitoredValue{ServerName: "FXH1", MonitorTitle: "Ping", MonitorStatus: "Alert"}, now) {
+		t.Error("The status of a monitor not collected yet should be ignored")
+	}
+	overlay.merge("", values, 0, now.Add(-2*time.Hour))
+	overlay.set("", MonitoredValue{ServerName: "FXH1", MonitorTitle: "Ping", MonitorStatus: "Alert", MonitorValue: "Alert", MonitorLastRun: now}, now)
+	overlay.set("", MonitoredValue{ServerName: "FXH1", MonitorTitle: "Disk", MonitorStatus: "Alert", MonitorValue: "Alert", MonitorLastRun: now}, now)
+	overlay.set("", MonitoredValue{ServerName: "FXH1", MonitorTitle: "CPU", MonitorStatus: "Alert", MonitorValue: "Alert", MonitorLastRun: now}, now.Add(-time.Hour))
+	if overlay.set("", MonitoredValue{ServerName: "FXH9", MonitorTitle: "Ping", MonitorStatus: "Alert"}, now) {
+		t.Error("The status of a monitor not collected should be ignored")
+	}
+
+	merged := overlay.merge("", values, 0, now)
+	for i, want := range []string{"Alert", "OK", "OK"} {
+		if merged[i].MonitorStatus != want {
+			t.Errorf("Wrong status of %s: got %s, want %s", merged[i].MonitorTitle, merged[i].MonitorStatus, want)
+		}
+	}
+	if values[0].MonitorStatus != "OK" {
+		t.Error("The collected values should not be modified")
+	}
+	if len(overlay.statuses[""]) != 1 {
+		t.Errorf("The expired and outdated statuses should be removed: got %v", overlay.statuses[""])
+	}
+	if merged := overlay.merge("other", values, 0, now); merged[0].MonitorStatus != "OK" {
+		t.Error("The statuses of another instance should not be merged")
+	}
+}
+
+func TestReloader_ListenSyslog(t *testing.T) {
+	tracker := NewStatusTracker()
+	tracker.update("", []MonitoredValue{
+		{MonitorID: "8937", MonitorTitle: "Ping", MonitorStatus: "OK", ServerName: "FXH1", GroupPath: "Dev"},
+	}, time.Now().Add(-time.Minute))
+	reloader := &Reloader{
+		tracker: tracker,
+		overlay: NewStatusOverlay(),
+	}
+	reloader.overlay.merge("", []MonitoredValue{{MonitorID: "8937", MonitorTitle: "Ping", MonitorStatus: "OK", ServerName: "FXH1", GroupPath: "Dev"}}, 0, time.Now())
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go reloader.serveSyslogUDP(conn)
+	defer conn.Close()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	matchedBefore := readCounter(syslogMessagesTotal, "matched")
+	if _, err := client.Write([]byte("<13>Mar  4 09:58:12 pa PowerAdmin: Monitor Ping on FXH1 is Alert: Timeout")); err != nil {
+		t.Fatal(err)
+	}
+
+	var state MonitorState
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		tracker.mutex.Lock()
+		state = *tracker.states[""]["8937"]
+		tracker.mutex.Unlock()
+		if state.Status == "alert" {
+			break
+		}
+	}
+	if state.Status != "alert" {
+		t.Errorf("The status of the monitor should be updated: got %+v", state)
+	}
+	if got := readCounter(syslogMessagesTotal, "matched"); got != matchedBefore+1 {
+		t.Errorf("Wrong number of matched messages: got %v, want %v", got, matchedBefore+1)
+	}
+	merged := reloader.overlay.merge("", []MonitoredValue{{ServerName: "FXH1", MonitorTitle: "Ping", MonitorStatus: "OK"}}, 0, time.Now())
+	if merged[0].MonitorStatus != "Alert" || merged[0].MonitorErrText != "Timeout" {
+		t.Errorf("The received status should be merged: got %+v", merged[0])
+	}
+}
+
+func TestReadSyslogFrame(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader("11 <13>message<13>line\n"))
+	for _, want := range []string{"<13>message", "<13>line\n"} {
+		got, err := readSyslogFrame(reader)
+		if err != nil || got != want {
+			t.Errorf("Wrong frame: got %q, %v, want %q", got, err, want)
+		}
+	}
+	if _, err := readSyslogFrame(bufio.NewReader(strings.NewReader("999999 <13>message"))); err == nil {
+		t.Error("A too long frame should be rejected")
+	}
+
+	reader = bufio.NewReaderSize(strings.NewReader("<13>"+strings.Repeat("x", 100)+"\n<13>next\n"), 16)
+	if _, err := readSyslogFrame(reader); err != errSyslogFrameTooLong {
+		t.Errorf("A too long line should be skipped: got %v", err)
+	}
+	if got, err := readSyslogFrame(reader); err != nil || got != "<13>next\n" {
+		t.Errorf("The frame following a too long line should be read: got %q, %v", got, err)
+	}
+}
+
+func TestSyslogConfig_Allowed(t *testing.T) {
+	config := SyslogConfig{}
+	if err := yaml.UnmarshalStrict([]byte("allowed_sources: [\"10.0.0.0/8\", \"192.168.1.10\", \"::1\"]"), &config); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.compile(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		source net.Addr
+		want   bool
+	}{
+		{&net.UDPAddr{IP: net.ParseIP("10.1.2.3")}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.168.1.10")}, true},
+		{&net.TCPAddr{IP: net.ParseIP("::1")}, true},
+		{&net.UDPAddr{IP: net.ParseIP("192.168.1.11")}, false},
+		{&net.UnixAddr{Name: "/tmp/syslog"}, false},
+	}
+	for _, test := range tests {
+		if got := config.allowed(test.source); got != test.want {
+			t.Errorf("Wrong result for %s: got %v, want %v", test.source, got, test.want)
+		}
+	}
+	if !(&SyslogConfig{}).allowed(&net.UDPAddr{IP: net.ParseIP("192.168.1.11")}) {
+		t.Error("All the sources should be allowed without allowed_sources")
+	}
+	if err := (&SyslogConfig{AllowedSources: []string{"10.0.0.0/33"}}).compile(); err == nil {
+		t.Error("An invalid allowed source should be rejected")
+	}
+}
+
+func TestReloader_HandleSyslog_Alerts(t *testing.T) {
+	reloader := &Reloader{tracker: NewStatusTracker(), overlay: NewStatusOverlay()}
+	reloader.overlay.merge("", []MonitoredValue{{MonitorTitle: "Ping", MonitorStatus: "OK", ServerName: "FXH1"}}, 0, time.Now())
+	alertsBefore := readCounter(syslogAlertsTotal, "FXH1", "Ping", "alert")
+	reloader.handleSyslog("<13>Monitor Ping on FXH1 is Alert", time.Now())
+	reloader.handleSyslog("<13>Monitor Spoofed on FXH9 is Alert", time.Now())
+	if got := readCounter(syslogAlertsTotal, "FXH1", "Ping", "alert"); got != alertsBefore+1 {
+		t.Errorf("The alerts of the collected monitors should be counted without status tracking: got %v, want %v", got, alertsBefore+1)
+	}
+	ch := make(chan prometheus.Metric)
+	go func() {
+		syslogAlertsTotal.Collect(ch)
+		close(ch)
+	}()
+	for m := range ch {
+		if readMetric(m).labels["server_name"] == "FXH9" {
+			t.Errorf("The alerts of the monitors not collected should not be counted: got %v", readMetric(m).labels)
+		}
+	}
+}
+
+func TestReloader_ServeSyslogUDP_Rejected(t *testing.T) {
+	reloader := &Reloader{
+		tracker: NewStatusTracker(),
+		overlay: NewStatusOverlay(),
+		config:  Config{Syslog: SyslogConfig{AllowedSources: []string{"10.0.0.0/8"}}},
+	}
+	if err := reloader.config.Syslog.compile(); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go reloader.serveSyslogUDP(conn)
+	defer conn.Close()
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	rejectedBefore := readCounter(syslogMessagesTotal, "rejected")
+	if _, err := client.Write([]byte("<13>Monitor Ping on FXH1 is Alert")); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline) && readCounter(syslogMessagesTotal, "rejected") == rejectedBefore; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := readCounter(syslogMessagesTotal, "rejected"); got != rejectedBefore+1 {
+		t.Errorf("Wrong number of rejected messages: got %v, want %v", got, rejectedBefore+1)
+	}
+	if len(reloader.overlay.statuses) != 0 {
+		t.Errorf("A rejected message should not be applied: got %v", reloader.overlay.statuses)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	defaultTimestampMaxAge    = time.Hour
+	defaultTimestampMaxFuture = 5 * time.Minute
+)
+
+// TimestampConfig attach the monitor last run time to the samples instead of the scrape time
+type TimestampConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	MaxAge    time.Duration `yaml:"max_age"`
+	MaxFuture time.Duration `yaml:"max_future"`
+}
+
+// sampleTimestamp returns the timestamp to attach to a sample and whether it should be attached.
+// Last run times too far in the past or in the future are ignored and the scrape time is used.
+func sampleTimestamp(lastRun time.Time, now time.Time, config TimestampConfig) (time.Time, bool) {
+	if !config.Enabled || lastRun.IsZero() {
+		return time.Time{}, false
+	}
+	maxAge := config.MaxAge
+	if maxAge <= 0 {
+		maxAge = defaultTimestampMaxAge
+	}
+	maxFuture := config.MaxFuture
+	if maxFuture <= 0 {
+		maxFuture = defaultTimestampMaxFuture
+	}
+	if lastRun.Before(now.Add(-maxAge)) || lastRun.After(now.Add(maxFuture)) {
+		return time.Time{}, false
+	}
+	return lastRun, true
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSampleTimestamp(t *testing.T) {
+	now := time.Date(2019, 4, 10, 13, 20, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		lastRun time.Time
+		config  TimestampConfig
+		want    bool
+	}{
+		{"disabled", now.Add(-time.Minute), TimestampConfig{}, false},
+		{"recent", now.Add(-time.Minute), TimestampConfig{Enabled: true}, true},
+		{"zero", time.Time{}, TimestampConfig{Enabled: true}, false},
+		{"too old", now.Add(-2 * time.Hour), TimestampConfig{Enabled: true}, false},
+		{"old with max age", now.Add(-2 * time.Hour), TimestampConfig{Enabled: true, MaxAge: 3 * time.Hour}, true},
+		{"future", now.Add(10 * time.Minute), TimestampConfig{Enabled: true}, false},
+		{"future with max future", now.Add(10 * time.Minute), TimestampConfig{Enabled: true, MaxFuture: time.Hour}, true},
+	}
+	for _, test := range tests {
+		got, ok := sampleTimestamp(test.lastRun, now, test.config)
+		if ok != test.want {
+			t.Errorf("%s: wrong result: got %v, want %v", test.name, ok, test.want)
+		}
+		if ok && !got.Equal(test.lastRun) {
+			t.Errorf("%s: wrong timestamp: got %v, want %v", test.name, got, test.lastRun)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StatusTrackingConfig remember the monitor statuses between scrapes to count the status changes
+type StatusTrackingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// StateFile keeps the monitor states across restarts when set
+	StateFile    string        `yaml:"state_file"`
+	SaveInterval time.Duration `yaml:"save_interval"`
+}
+
+// MonitorState the tracked status of a monitor
+type MonitorState struct {
+	MonitorID  string             `json:"monitorID"`
+	GroupPath  string             `json:"groupPath"`
+	ServerName string             `json:"serverName"`
+	Title      string             `json:"title"`
+	Status     string             `json:"status"`
+	Since      time.Time          `json:"since"`
+	LastSeen   time.Time          `json:"lastSeen"`
+	Changes    float64            `json:"changes"`
+	Seconds    map[string]float64 `json:"seconds"`
+}
+
+// StatusTracker keeps the state of the monitors of each instance, it outlives the collectors rebuilt on reload
+type StatusTracker struct {
+	mutex sync.Mutex
+	// states monitor states by instance name and monitor key
+	states map[string]map[string]*MonitorState
+}
+
+// NewStatusTracker returns an empty tracker
+func NewStatusTracker() *StatusTracker {
+	return &StatusTracker{states: make(map[string]map[string]*MonitorState)}
+}
+
+// monitorKey identifies a monitor, by its ID or by its server and title for the monitors without ID
+func monitorKey(value MonitoredValue) string {
+	if value.MonitorID != "" {
+		return value.MonitorID
+	}
+	return value.ServerID + "/" + value.MonitorTitle
+}
+
+// update records the statuses of a successful collection of the instance and returns the status changes.
+// The monitors no longer collected are forgotten.
+func (t *StatusTracker) update(instance string, values []MonitoredValue, now time.Time) []StatusEvent {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	previous := t.states[instance]
+	current := make(map[string]*MonitorState, len(values))
+	events := make([]StatusEvent, 0)
+	for _, value := range values {
+		key := monitorKey(value)
+		state, exists := previous[key]
+		if !exists {
+			status := strings.ToLower(value.MonitorStatus)
+			state = &MonitorState{Status: status, Since: now, LastSeen: now, Seconds: map[string]float64{status: 0}}
+		}
+		state.MonitorID = value.MonitorID
+		state.GroupPath = value.GroupPath
+		state.ServerName = value.ServerName
+		state.Title = value.MonitorTitle
+		if event := state.record(instance, value, now); event != nil {
+			events = append(events, *event)
+		}
+		current[key] = state
+	}
+	t.states[instance] = current
+	return events
+}
+
+// apply records the status of one monitor of the instance, found by its ID or by its server, title and group path when set.
+// It returns the status change and false when the monitor is not tracked.
+func (t *StatusTracker) apply(instance string, value MonitoredValue, now time.Time) (*StatusEvent, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	states := t.states[instance]
+	state, found := states[value.MonitorID]
+	if value.MonitorID == "" {
+		for _, candidate := range states {
+			if candidate.ServerName == value.ServerName && candidate.Title == value.MonitorTitle && (value.GroupPath == "" || candidate.GroupPath == value.GroupPath) {
+				state, found = candidate, true
+				break
+			}
+		}
+	}
+	if !found {
+		return nil, false
+	}
+	return state.record(instance, value, now), true
+}
+
+// record applies the status of the monitor and returns the status change, nil when the status didn't change
+func (s *MonitorState) record(instance string, value MonitoredValue, now time.Time) *StatusEvent {
+	status := strings.ToLower(value.MonitorStatus)
+	if elapsed := now.Sub(s.LastSeen).Seconds(); elapsed > 0 {
+		s.Seconds[s.Status] += elapsed
+		s.LastSeen = now
+	}
+	if _, counted := s.Seconds[status]; !counted {
+		s.Seconds[status] = 0
+	}
+	if s.Status == status {
+		return nil
+	}
+	event := &StatusEvent{
+		Time:       now,
+		Instance:   instance,
+		GroupPath:  s.GroupPath,
+		ServerName: s.ServerName,
+		MonitorID:  s.MonitorID,
+		Monitor:    s.Title,
+		OldStatus:  s.Status,
+		NewStatus:  status,
+		LastRun:    value.MonitorLastRun,
+		ErrText:    value.MonitorErrText,
+	}
+	s.Changes++
+	s.Status = status
+	s.Since = now
+	return event
+}
+
+// collect sends the status change metrics of the monitors of the instance
+func (t *StatusTracker) collect(instance string, ch chan<- prometheus.Metric) {
+	labelNames := []string{"group_path", "server_name", "monitor"}
+	if instance != "" {
+		labelNames = append(labelNames, instanceLabel)
+	}
+	statusLabelNames := append(append([]string{}, labelNames...), "status")
+	changesDesc := prometheus.NewDesc("poweradmin_monitor_status_changes_total", "Number of status changes of the monitor", labelNames, nil)
+	sinceDesc := prometheus.NewDesc("poweradmin_monitor_status_since_timestamp_seconds", "Timestamp of the start of the current status of the monitor", statusLabelNames, nil)
+	secondsDesc := prometheus.NewDesc("poweradmin_monitor_status_seconds_total", "Seconds spent by the monitor in each status", statusLabelNames, nil)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, state := range t.states[instance] {
+		labelValues := []string{state.GroupPath, state.ServerName, state.Title}
+		if instance != "" {
+			labelValues = append(labelValues, instance)
+		}
+		ch <- prometheus.MustNewConstMetric(changesDesc, prometheus.CounterValue, state.Changes, labelValues...)
+		ch <- prometheus.MustNewConstMetric(sinceDesc, prometheus.GaugeValue, float64(state.Since.UnixNano())/1e9, append(labelValues, state.Status)...)
+		for status, seconds := range state.Seconds {
+			ch <- prometheus.MustNewConstMetric(secondsDesc, prometheus.CounterValue, seconds, append(labelValues, status)...)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestStatusTracker_Update(t *testing.T) {
+	tracker := NewStatusTracker()
+	start := time.Unix(1000, 0)
+	value := MonitoredValue{MonitorID: "8937", MonitorTitle: "Ping", MonitorStatus: "OK", ServerName: "FXH1", GroupPath: "Dev"}
+
+	tracker.update("", []MonitoredValue{value}, start)
+	value.MonitorStatus = "Alert"
+	tracker.update("", []MonitoredValue{value}, start.Add(60*time.Second))
+	tracker.update("", []MonitoredValue{value}, start.Add(90*time.Second))
+	value.MonitorStatus = "OK"
+	tracker.update("", []MonitoredValue{value}, start.Add(100*time.Second))
+
+	state := tracker.states[""]["8937"]
+	if state.Changes != 2 {
+		t.Errorf("Wrong number of changes: got %v, want %v", state.Changes, 2)
+	}
+	if state.Status != "ok" || !state.Since.Equal(start.Add(100*time.Second)) {
+		t.Errorf("Wrong current status: got %v since %v", state.Status, state.Since)
+	}
+	if state.Seconds["ok"] != 60 || state.Seconds["alert"] != 40 {
+		t.Errorf("Wrong seconds per status: got %v", state.Seconds)
+	}
+
+	tracker.update("", []MonitoredValue{}, start.Add(110*time.Second))
+	if len(tracker.states[""]) != 0 {
+		t.Errorf("A monitor no longer collected should be forgotten")
+	}
+}
+
+func TestCollector_Collect_StatusTracking(t *testing.T) {
+	api := MockPAExternalAPI{}
+	values := MonitoredValues{Values: []MonitoredValue{
+		{MonitorID: "1", MonitorTitle: "Ping", MonitorValue: "OK", MonitorStatus: "OK", ServerName: "FXH1", GroupPath: "Dev"},
+	}}
+	api.On("GetResources", mock.Anything).Return(&values, nil)
+	collector := NewCollector(&api, Config{InstanceName: "prod", StatusTracking: StatusTrackingConfig{Enabled: true}})
+	collector.Tracker = NewStatusTracker()
+
+	names := make(map[string]MetricResult)
+	for i := 0; i < 2; i++ {
+		if i == 1 {
+			values.Values[0].MonitorStatus = "Alert"
+		}
+		ch := make(chan prometheus.Metric)
+		go func() {
+			collector.Collect(ch)
+			close(ch)
+		}()
+		for m := range ch {
+			got := readMetric(m)
+			names[metricName(m)+got.labels["status"]] = got
+		}
+	}
+	changes, exists := names["poweradmin_monitor_status_changes_total"]
+	if !exists || changes.value != 1 {
+		t.Fatalf("Wrong status changes metric: got %+v", names)
+	}
+	if changes.labels["monitor"] != "Ping" || changes.labels[instanceLabel] != "prod" {
+		t.Errorf("Wrong labels for the status changes metric: got %v", changes.labels)
+	}
+	if _, exists := names["poweradmin_monitor_status_since_timestamp_secondsalert"]; !exists {
+		t.Errorf("The since timestamp should have the current status: got %+v", names)
+	}
+	if _, exists := names["poweradmin_monitor_status_seconds_totalok"]; !exists {
+		t.Errorf("The seconds of the previous status should be exported: got %+v", names)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+// TLSConfig TLS settings of the connection to PowerAdmin
+type TLSConfig struct {
+	CAFile     string `yaml:"ca_file"`
+	CertFile   string `yaml:"cert_file"`
+	KeyFile    string `yaml:"key_file"`
+	ServerName string `yaml:"server_name"`
+	MinVersion string `yaml:"min_version"`
+}
+
+// ProxyConfig HTTP(S) proxy used to reach PowerAdmin
+type ProxyConfig struct {
+	URL     string `yaml:"url"`
+	NoProxy string `yaml:"no_proxy"`
+}
+
+// resolvePaths makes the relative file paths relative to the config folder
+func (c *TLSConfig) resolvePaths(configDir string) {
+	c.CAFile = resolveConfigPath(configDir, c.CAFile)
+	c.CertFile = resolveConfigPath(configDir, c.CertFile)
+	c.KeyFile = resolveConfigPath(configDir, c.KeyFile)
+}
+
+// newTLSConfig builds the TLS config of the PowerAdmin client
+func newTLSConfig(config TLSConfig, skipTLSVerify bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: skipTLSVerify, // ignore expired SSL certificates
+		ServerName:         config.ServerName,
+	}
+	if config.MinVersion != "" {
+		version, exists := tlsVersions[strings.ToUpper(config.MinVersion)]
+		if !exists {
+			return nil, fmt.Errorf("invalid TLS min_version %q, must be one of TLS10, TLS11, TLS12 or TLS13", config.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+	if config.CAFile != "" {
+		caData, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no certificate found in the CA file %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, errors.New("cert_file and key_file must be both set for client certificate authentication")
+	}
+	if config.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading the client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
+// newProxyFunc returns the proxy function of the transport, nil when no proxy is configured
+func newProxyFunc(config ProxyConfig) (func(*http.Request) (*url.URL, error), error) {
+	if config.URL == "" {
+		return nil, nil
+	}
+	proxyURL, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url: %v", err)
+	}
+	if proxyURL.Scheme != "http" && proxyURL.Scheme != "https" {
+		return nil, fmt.Errorf("invalid proxy url %q, the scheme must be http or https", config.URL)
+	}
+	noProxy := strings.Split(config.NoProxy, ",")
+	return func(req *http.Request) (*url.URL, error) {
+		if matchNoProxy(req.URL.Hostname(), noProxy) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}, nil
+}
+
+// matchNoProxy tells if a host matches the no proxy rules: "*", host names, domains (a leading dot is optional), IPs and CIDRs
+func matchNoProxy(host string, rules []string) bool {
+	host = strings.ToLower(host)
+	hostIP := net.ParseIP(host)
+	for _, rule := range rules {
+		rule = strings.ToLower(strings.TrimSpace(rule))
+		if rule == "" {
+			continue
+		}
+		if rule == "*" {
+			return true
+		}
+		if _, network, err := net.ParseCIDR(rule); err == nil {
+			if hostIP != nil && network.Contains(hostIP) {
+				return true
+			}
+			continue
+		}
+		domain := strings.TrimPrefix(rule, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// newTransport builds the transport of the PowerAdmin client from the config
+func newTransport(config Config) (*http.Transport, error) {
+	tlsConfig, err := newTLSConfig(config.TLSConfig, config.SkipTLSVerify)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := newProxyFunc(config.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Transport{
+		TLSClientConfig: tlsConfig,
+		Proxy:           proxy,
+	}, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeServerCertificate writes the certificate and key of a test server as PEM files
+func writeServerCertificate(t *testing.T, ts *httptest.Server) (string, string, string) {
+	key, err := x509.MarshalPKCS8PrivateKey(ts.TLS.Certificates[0].PrivateKey)
+	if err != nil {
+		t.Fatalf("Error marshalling the key: %v", err)
+	}
+	dir := writeConfigFiles(t, map[string]string{
+		"cert.pem": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})),
+		"key.pem":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})),
+	})
+	return dir, filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+}
+
+func TestNewClientFromConfig_TLS(t *testing.T) {
+	groupHandler := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(groupListString))
+	}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(groupHandler))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+	dir, certFile, keyFile := writeServerCertificate(t, ts)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name      string
+		tlsConfig TLSConfig
+		success   bool
+	}{
+		{"no CA", TLSConfig{CertFile: certFile, KeyFile: keyFile}, false},
+		{"no client certificate", TLSConfig{CAFile: certFile, ServerName: "example.com"}, false},
+		{"CA and client certificate", TLSConfig{CAFile: certFile, CertFile: certFile, KeyFile: keyFile, ServerName: "example.com", MinVersion: "TLS12"}, true},
+	}
+	for _, test := range tests {
+		client, err := newClientFromConfig(Config{APIKey: "1234key", ServerURL: ts.URL, TLSConfig: test.tlsConfig})
+		if err != nil {
+			t.Fatalf("%s: error should be nil: got %v", test.name, err)
+		}
+		_, err = client.GetGroupList()
+		if test.success && err != nil {
+			t.Errorf("%s: error should be nil: got %v", test.name, err)
+		}
+		if !test.success && err == nil {
+			t.Errorf("%s: error shouldn't be nil", test.name)
+		}
+	}
+}
+
+func TestNewTLSConfig_Errors(t *testing.T) {
+	invalid := []TLSConfig{
+		{MinVersion: "SSL3"},
+		{CertFile: "cert.pem"},
+		{CAFile: "nosuchfile.pem"},
+		{CertFile: "nosuchfile.pem", KeyFile: "nosuchfile.pem"},
+	}
+	for _, config := range invalid {
+		if _, err := newTLSConfig(config, false); err == nil {
+			t.Errorf("Config %+v should raise an error", config)
+		}
+	}
+}
+
+func TestNewClientFromConfig_Proxy(t *testing.T) {
+	proxied := false
+	proxyHandler := func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.Host == "paserver.invalid"
+		_, _ = w.Write([]byte(groupListString))
+	}
+	ts := httptest.NewServer(http.HandlerFunc(proxyHandler))
+	defer ts.Close()
+
+	client, err := newClientFromConfig(Config{APIKey: "1234key", ServerURL: "http://paserver.invalid", Proxy: ProxyConfig{URL: ts.URL}})
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	if _, err := client.GetGroupList(); err != nil {
+		t.Errorf("Error should be nil: got %v", err)
+	}
+	if !proxied {
+		t.Errorf("The request should go through the proxy")
+	}
+	if _, err := newClientFromConfig(Config{APIKey: "1234key", Proxy: ProxyConfig{URL: "socks5://proxy"}}); err == nil {
+		t.Errorf("A proxy url which is not http or https should raise an error")
+	}
+}
+
+func TestMatchNoProxy(t *testing.T) {
+	rules := []string{"localhost", ".internal.com", "example.org", "10.0.0.0/8"}
+	tests := map[string]bool{
+		"localhost":          true,
+		"pa.internal.com":    true,
+		"internal.com":       true,
+		"www.example.org":    true,
+		"10.1.2.3":           true,
+		"11.1.2.3":           false,
+		"paserver.com":       false,
+		"notinternal.com":    false,
+		"example.org.evil.c": false,
+	}
+	for host, want := range tests {
+		if got := matchNoProxy(host, rules); got != want {
+			t.Errorf("Wrong result for %s: got %v, want %v", host, got, want)
+		}
+	}
+	if !matchNoProxy("paserver.com", []string{"*"}) {
+		t.Errorf("* should match all the hosts")
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// dummyPasswordHash is compared for unknown users so that the response time doesn't tell which users exist
+	dummyPasswordHash = "$2a$10$inOgPkIYldjsaTgQnvPh8uQBp9TkZItCxbbSPVZFGx9JU9QcKh1t6"
+	// maxAuthCacheEntries bounds the successful credential checks kept, the cache is emptied when full
+	maxAuthCacheEntries = 1000
+)
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// cipherSuites the TLS 1.0-1.2 cipher suites by name, the TLS 1.3 ones are not configurable
+var cipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_AES_128_CBC_SHA":                  tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":                  tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":               tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":               tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256":       tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384":       tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":          tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256":   tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":        tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256": tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+}
+
+var curves = map[string]tls.CurveID{
+	"CurveP256": tls.CurveP256,
+	"CurveP384": tls.CurveP384,
+	"CurveP521": tls.CurveP521,
+	"X25519":    tls.X25519,
+}
+
+// securityHeaders the response headers which can be set by http_server_config, as in the exporter-toolkit
+var securityHeaders = map[string]bool{
+	"Content-Security-Policy":   true,
+	"Strict-Transport-Security": true,
+	"X-Content-Type-Options":    true,
+	"X-Frame-Options":           true,
+	"X-XSS-Protection":          true,
+}
+
+// WebConfig TLS and authentication of the exporter web endpoint, in the Prometheus exporter-toolkit web config format
+type WebConfig struct {
+	TLSServerConfig  TLSServerConfig   `yaml:"tls_server_config"`
+	HTTPServerConfig HTTPServerConfig  `yaml:"http_server_config"`
+	BasicAuthUsers   map[string]string `yaml:"basic_auth_users"`
+}
+
+// TLSServerConfig TLS settings of the exporter web endpoint
+type TLSServerConfig struct {
+	CertFile                 string   `yaml:"cert_file"`
+	KeyFile                  string   `yaml:"key_file"`
+	ClientAuthType           string   `yaml:"client_auth_type"`
+	ClientCAFile             string   `yaml:"client_ca_file"`
+	MinVersion               string   `yaml:"min_version"`
+	MaxVersion               string   `yaml:"max_version"`
+	CipherSuites             []string `yaml:"cipher_suites"`
+	CurvePreferences         []string `yaml:"curve_preferences"`
+	PreferServerCipherSuites *bool    `yaml:"prefer_server_cipher_suites"`
+}
+
+// HTTPServerConfig HTTP settings of the exporter web endpoint
+type HTTPServerConfig struct {
+	HTTP2   *bool             `yaml:"http2"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// loadWebConfig reads a web config file, relative paths in the file being relative to the file folder
+func loadWebConfig(webConfigFile string) (WebConfig, error) {
+	webConfig := WebConfig{}
+	data, err := ioutil.ReadFile(webConfigFile)
+	if err != nil {
+		return webConfig, err
+	}
+	if err := yaml.UnmarshalStrict(data, &webConfig); err != nil {
+		return webConfig, fmt.Errorf("%s: %v", webConfigFile, err)
+	}
+	dir := filepath.Dir(webConfigFile)
+	webConfig.TLSServerConfig.CertFile = resolveConfigPath(dir, webConfig.TLSServerConfig.CertFile)
+	webConfig.TLSServerConfig.KeyFile = resolveConfigPath(dir, webConfig.TLSServerConfig.KeyFile)
+	webConfig.TLSServerConfig.ClientCAFile = resolveConfigPath(dir, webConfig.TLSServerConfig.ClientCAFile)
+	for user, hash := range webConfig.BasicAuthUsers {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return webConfig, fmt.Errorf("%s: invalid bcrypt hash for user %s: %v", webConfigFile, user, err)
+		}
+	}
+	for header := range webConfig.HTTPServerConfig.Headers {
+		if !securityHeaders[http.CanonicalHeaderKey(header)] {
+			return webConfig, fmt.Errorf("%s: the header %s can't be set", webConfigFile, header)
+		}
+	}
+	return webConfig, nil
+}
+
+// tlsEnabled tells if the web endpoint has to be served over HTTPS
+func (c *WebConfig) tlsEnabled() bool {
+	return c.TLSServerConfig.CertFile != "" || c.TLSServerConfig.KeyFile != ""
+}
+
+// newServerTLSConfig builds the TLS config of the web endpoint
+func newServerTLSConfig(config TLSServerConfig) (*tls.Config, error) {
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, errors.New("cert_file and key_file must be both set to serve over TLS")
+	}
+	certificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading the server certificate: %v", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates:             []tls.Certificate{certificate},
+		MinVersion:               tls.VersionTLS12,
+		PreferServerCipherSuites: true,
+	}
+	if config.PreferServerCipherSuites != nil {
+		tlsConfig.PreferServerCipherSuites = *config.PreferServerCipherSuites
+	}
+	for _, name := range config.CipherSuites {
+		cipherSuite, exists := cipherSuites[name]
+		if !exists {
+			return nil, fmt.Errorf("invalid cipher suite %q", name)
+		}
+		tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, cipherSuite)
+	}
+	for _, name := range config.CurvePreferences {
+		curve, exists := curves[name]
+		if !exists {
+			return nil, fmt.Errorf("invalid curve %q", name)
+		}
+		tlsConfig.CurvePreferences = append(tlsConfig.CurvePreferences, curve)
+	}
+	if config.MinVersion != "" {
+		version, exists := tlsVersions[strings.ToUpper(config.MinVersion)]
+		if !exists {
+			return nil, fmt.Errorf("invalid min_version %q", config.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+	if config.MaxVersion != "" {
+		version, exists := tlsVersions[strings.ToUpper(config.MaxVersion)]
+		if !exists {
+			return nil, fmt.Errorf("invalid max_version %q", config.MaxVersion)
+		}
+		tlsConfig.MaxVersion = version
+	}
+	if config.ClientAuthType != "" {
+		clientAuth, exists := clientAuthTypes[config.ClientAuthType]
+		if !exists {
+			return nil, fmt.Errorf("invalid client_auth_type %q", config.ClientAuthType)
+		}
+		tlsConfig.ClientAuth = clientAuth
+	}
+	if config.ClientCAFile != "" {
+		caData, err := ioutil.ReadFile(config.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the client CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no certificate found in the client CA file %s", config.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+	} else if tlsConfig.ClientAuth == tls.VerifyClientCertIfGiven || tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert {
+		return nil, errors.New("client_ca_file must be set to verify the client certificates")
+	}
+	return tlsConfig, nil
+}
+
+// authCache the successful credential checks, as a bcrypt comparison on each scrape is slow on purpose
+type authCache struct {
+	mutex   sync.Mutex
+	checked map[string]bool
+}
+
+// key returns the cache key of the credentials, the password is not kept in memory
+func (c *authCache) key(user string, password string, hash string) string {
+	sum := sha256.Sum256([]byte(user + "\x00" + password + "\x00" + hash))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *authCache) contains(key string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.checked[key]
+}
+
+func (c *authCache) add(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.checked) >= maxAuthCacheEntries {
+		c.checked = make(map[string]bool)
+	}
+	c.checked[key] = true
+}
+
+// basicAuthHandler requires one of the configured users on each request, does nothing without users
+func basicAuthHandler(users map[string]string, handler http.Handler) http.Handler {
+	if len(users) == 0 {
+		return handler
+	}
+	cache := &authCache{checked: make(map[string]bool)}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if ok {
+			hash, userExists := users[user]
+			if !userExists {
+				hash = dummyPasswordHash
+			}
+			key := cache.key(user, password, hash)
+			if userExists && cache.contains(key) {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil && userExists {
+				cache.add(key)
+				handler.ServeHTTP(w, r)
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="PowerAdmin Exporter"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
+
+// listenAndServe serves the handler on the address, with the TLS and authentication of the web config file if set
+func listenAndServe(address string, webConfigFile string, handler http.Handler) error {
+	if webConfigFile == "" {
+		return http.ListenAndServe(address, handler)
+	}
+	webConfig, err := loadWebConfig(webConfigFile)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Addr:    address,
+		Handler: headersHandler(webConfig.HTTPServerConfig.Headers, basicAuthHandler(webConfig.BasicAuthUsers, handler)),
+	}
+	if !webConfig.tlsEnabled() {
+		return server.ListenAndServe()
+	}
+	server.TLSConfig, err = newServerTLSConfig(webConfig.TLSServerConfig)
+	if err != nil {
+		return err
+	}
+	if http2 := webConfig.HTTPServerConfig.HTTP2; http2 != nil && !*http2 {
+		// a non nil map disables HTTP/2
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+	return server.ListenAndServeTLS("", "")
+}
+
+// headersHandler sets the configured headers on all the responses
+func headersHandler(headers map[string]string, handler http.Handler) http.Handler {
+	if len(headers) == 0 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range headers {
+			w.Header().Set(name, value)
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
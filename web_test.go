@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// bcrypt hash of "test" with the minimum cost
+const testPasswordHash = "$2a$04$Wk.nmmi1g46EYYvi24U62.NIFw1vAOXRNcqEFVf/8mXcxksUAmUFS"
+
+func TestBasicAuthHandler(t *testing.T) {
+	handler := basicAuthHandler(map[string]string{"prometheus": testPasswordHash}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("metrics"))
+	}))
+	tests := []struct {
+		user     string
+		password string
+		auth     bool
+		want     int
+	}{
+		{"prometheus", "test", true, http.StatusOK},
+		{"prometheus", "wrong", true, http.StatusUnauthorized},
+		{"unknown", "test", true, http.StatusUnauthorized},
+		{"", "", false, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if test.auth {
+			req.SetBasicAuth(test.user, test.password)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != test.want {
+			t.Errorf("Wrong status for user %q: got %v, want %v", test.user, recorder.Code, test.want)
+		}
+	}
+}
+
+func TestLoadWebConfig(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"web.yml":     "tls_server_config:\n  cert_file: cert.pem\n  key_file: key.pem\nbasic_auth_users:\n  prometheus: " + testPasswordHash + "\n",
+		"invalid.yml": "basic_auth_users:\n  prometheus: notahash\n",
+		"unknown.yml": "tls_server_config:\n  cert_fil: cert.pem\n",
+		"toolkit.yml": "tls_server_config:\n  cert_file: cert.pem\n  key_file: key.pem\n  cipher_suites: [TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256]\n  curve_preferences: [X25519]\n  prefer_server_cipher_suites: false\nhttp_server_config:\n  http2: false\n  headers:\n    X-Frame-Options: deny\n",
+		"header.yml":  "http_server_config:\n  headers:\n    Server: poweradmin\n",
+	})
+	defer os.RemoveAll(dir)
+
+	webConfig, err := loadWebConfig(filepath.Join(dir, "web.yml"))
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	if webConfig.TLSServerConfig.CertFile != filepath.Join(dir, "cert.pem") {
+		t.Errorf("Wrong cert file: got %v, want %v", webConfig.TLSServerConfig.CertFile, filepath.Join(dir, "cert.pem"))
+	}
+	if !webConfig.tlsEnabled() {
+		t.Errorf("TLS should be enabled")
+	}
+	webConfig, err = loadWebConfig(filepath.Join(dir, "toolkit.yml"))
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	if http2 := webConfig.HTTPServerConfig.HTTP2; http2 == nil || *http2 || webConfig.HTTPServerConfig.Headers["X-Frame-Options"] != "deny" {
+		t.Errorf("Wrong http_server_config: got %+v", webConfig.HTTPServerConfig)
+	}
+	for _, name := range []string{"invalid.yml", "unknown.yml", "header.yml", "nosuchfile.yml"} {
+		if _, err := loadWebConfig(filepath.Join(dir, name)); err == nil {
+			t.Errorf("Web config %s should raise an error", name)
+		}
+	}
+}
+
+func TestNewServerTLSConfig(t *testing.T) {
+	certServer := httptest.NewTLSServer(http.NotFoundHandler())
+	certServer.Close()
+	dir, certFile, keyFile := writeServerCertificate(t, certServer)
+	defer os.RemoveAll(dir)
+
+	tlsConfig, err := newServerTLSConfig(TLSServerConfig{
+		CertFile:       certFile,
+		KeyFile:        keyFile,
+		ClientAuthType: "RequireAnyClientCert",
+		ClientCAFile:   certFile,
+	})
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("metrics"))
+	}))
+	ts.TLS = tlsConfig
+	ts.StartTLS()
+	defer ts.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(certServer.Certificate())
+	tlsClientConfig := &tls.Config{RootCAs: pool, ServerName: "example.com"}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsClientConfig}}
+	if _, err := client.Get(ts.URL); err == nil {
+		t.Errorf("A request without client certificate should fail")
+	}
+	tlsClientConfig.Certificates = certServer.TLS.Certificates
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Wrong status: got %v, want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	invalid := []TLSServerConfig{
+		{CertFile: certFile},
+		{CertFile: certFile, KeyFile: keyFile, ClientAuthType: "Unknown"},
+		{CertFile: certFile, KeyFile: keyFile, ClientAuthType: "RequireAndVerifyClientCert"},
+		{CertFile: certFile, KeyFile: keyFile, MinVersion: "SSL3"},
+		{CertFile: certFile, KeyFile: keyFile, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{CertFile: certFile, KeyFile: keyFile, CurvePreferences: []string{"CurveP224"}},
+	}
+	for _, config := range invalid {
+		if _, err := newServerTLSConfig(config); err == nil {
+			t.Errorf("Config %+v should raise an error", config)
+		}
+	}
+}
+
+func TestNewServerTLSConfig_CipherSuites(t *testing.T) {
+	certServer := httptest.NewTLSServer(http.NotFoundHandler())
+	certServer.Close()
+	dir, certFile, keyFile := writeServerCertificate(t, certServer)
+	defer os.RemoveAll(dir)
+
+	preferServer := false
+	tlsConfig, err := newServerTLSConfig(TLSServerConfig{
+		CertFile:                 certFile,
+		KeyFile:                  keyFile,
+		CipherSuites:             []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"},
+		CurvePreferences:         []string{"X25519", "CurveP256"},
+		PreferServerCipherSuites: &preferServer,
+	})
+	if err != nil {
+		t.Fatalf("Error should be nil: got %v", err)
+	}
+	if len(tlsConfig.CipherSuites) != 2 || tlsConfig.CipherSuites[1] != tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305 {
+		t.Errorf("Wrong cipher suites: got %v", tlsConfig.CipherSuites)
+	}
+	if len(tlsConfig.CurvePreferences) != 2 || tlsConfig.CurvePreferences[0] != tls.X25519 {
+		t.Errorf("Wrong curves: got %v", tlsConfig.CurvePreferences)
+	}
+	if tlsConfig.PreferServerCipherSuites {
+		t.Errorf("The server cipher suites should not be preferred")
+	}
+}
+
+func TestHeadersHandler(t *testing.T) {
+	handler := headersHandler(map[string]string{"X-Frame-Options": "deny"}, http.NotFoundHandler())
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if got := recorder.Header().Get("X-Frame-Options"); got != "deny" {
+		t.Errorf("Wrong X-Frame-Options header: got %q, want %q", got, "deny")
+	}
+}
+
+func TestAuthCache(t *testing.T) {
+	cache := &authCache{checked: make(map[string]bool)}
+	key := cache.key("prometheus", "test", testPasswordHash)
+	if cache.contains(key) {
+		t.Errorf("The cache should be empty")
+	}
+	cache.add(key)
+	if !cache.contains(key) || cache.contains(cache.key("prometheus", "wrong", testPasswordHash)) {
+		t.Errorf("Only the added credentials should be cached")
+	}
+	for i := 0; i < maxAuthCacheEntries; i++ {
+		cache.add(cache.key("user", string(rune(i)), testPasswordHash))
+	}
+	if len(cache.checked) > maxAuthCacheEntries {
+		t.Errorf("The cache should be bounded: got %v entries", len(cache.checked))
+	}
+}
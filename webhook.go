@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+var alertActionsReceivedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "poweradmin_alert_actions_received_total",
+	Help: "Number of PowerAdmin alert action callbacks received on the webhook",
+}, []string{"status"})
+
+// WebhookConfig the /webhook/poweradmin endpoint receiving the PowerAdmin HTTP alert actions, enabled when a token is set
+type WebhookConfig struct {
+	Token     string        `yaml:"token"`
+	TokenFile string        `yaml:"token_file"`
+	Fields    WebhookFields `yaml:"fields"`
+}
+
+// WebhookFields names of the query or form parameters holding the monitor fields
+type WebhookFields struct {
+	Instance  string `yaml:"instance"`
+	Group     string `yaml:"group"`
+	Server    string `yaml:"server"`
+	MonitorID string `yaml:"monitor_id"`
+	Monitor   string `yaml:"monitor"`
+	Status    string `yaml:"status"`
+	ErrText   string `yaml:"err_text"`
+}
+
+// readTokenFile sets the token from the token_file, a relative path being relative to the config folder
+func (c *WebhookConfig) readTokenFile(configDir string) error {
+	if c.TokenFile == "" {
+		return nil
+	}
+	if c.Token != "" {
+		return errors.New("webhook token and token_file cannot be both set")
+	}
+	token, err := ioutil.ReadFile(resolveConfigPath(configDir, c.TokenFile))
+	if err != nil {
+		return fmt.Errorf("error reading the webhook token file: %v", err)
+	}
+	c.Token = strings.TrimSpace(string(token))
+	return nil
+}
+
+// param returns the value of the parameter, name being the configured parameter name or the default one
+func param(req *http.Request, name string, defaultName string) string {
+	if name == "" {
+		name = defaultName
+	}
+	return req.FormValue(name)
+}
+
+// authorized checks the token of the request, given as a bearer token or in the token parameter
+func (c *WebhookConfig) authorized(req *http.Request) bool {
+	token := req.FormValue("token")
+	if authorization := req.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		token = strings.TrimPrefix(authorization, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(c.Token)) == 1
+}
+
+// WebhookHandler receives the PowerAdmin alert action callbacks and updates the status of the collected monitor right away
+func (r *Reloader) WebhookHandler(w http.ResponseWriter, req *http.Request) {
+	config := r.Config().Webhook
+	if config.Token == "" {
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Only GET and POST requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !config.authorized(req) {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	fields := config.Fields
+	value := MonitoredValue{
+		GroupPath:      param(req, fields.Group, "group"),
+		ServerName:     param(req, fields.Server, "server"),
+		MonitorID:      param(req, fields.MonitorID, "monitor_id"),
+		MonitorTitle:   param(req, fields.Monitor, "monitor"),
+		MonitorStatus:  param(req, fields.Status, "status"),
+		MonitorErrText: param(req, fields.ErrText, "err_text"),
+		MonitorLastRun: time.Now(),
+	}
+	if value.MonitorStatus == "" || (value.MonitorID == "" && (value.ServerName == "" || value.MonitorTitle == "")) {
+		http.Error(w, "the status and either the monitor ID or the server and monitor are required", http.StatusBadRequest)
+		return
+	}
+	alertActionsReceivedTotal.WithLabelValues(strings.ToLower(value.MonitorStatus)).Inc()
+
+	if !r.applyMonitorStatus(param(req, fields.Instance, "instance"), value) {
+		log.Debugf("Ignored the alert action received for the monitor %s of %s which was not collected", value.MonitorTitle, value.ServerName)
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintln(w, "monitor not collected, the status is ignored")
+	}
+}
+
+// applyMonitorStatus keeps a status received between two scrapes in the overlay so that the next scrapes export it
+// until the monitor runs again, updates the tracked status of the monitor and publishes the status change.
+// It returns false and ignores the status when the monitor was not in the last collection.
+func (r *Reloader) applyMonitorStatus(instance string, value MonitoredValue) bool {
+	if !r.overlay.set(instance, value, time.Now()) {
+		return false
+	}
+	if event, _ := r.tracker.apply(instance, value, value.MonitorLastRun); event != nil {
+		r.mutex.RLock()
+		events := r.events
+		r.mutex.RUnlock()
+		events.publish([]StatusEvent{*event})
+	}
+	return true
+}
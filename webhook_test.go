@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestReloader_WebhookHandler(t *testing.T) {
+	tracker := NewStatusTracker()
+	tracker.update("", []MonitoredValue{
+		{MonitorID: "8937", MonitorTitle: "Ping", MonitorStatus: "OK", ServerName: "FXH1", GroupPath: "Dev"},
+		{MonitorID: "8938", MonitorTitle: "Disk", MonitorStatus: "OK", ServerName: "FXH1", GroupPath: "Dev"},
+	}, time.Now().Add(-time.Minute))
+	overlay := NewStatusOverlay()
+	overlay.merge("", []MonitoredValue{
+		{MonitorID: "8937", MonitorTitle: "Ping", MonitorStatus: "OK", ServerName: "FXH1", GroupPath: "Dev"},
+		{MonitorID: "8938", MonitorTitle: "Disk", MonitorStatus: "OK", ServerName: "FXH1", GroupPath: "Dev"},
+	}, 0, time.Now())
+	reloader := &Reloader{
+		tracker: tracker,
+		overlay: overlay,
+		config: Config{Webhook: WebhookConfig{
+			Token:  "secret",
+			Fields: WebhookFields{Server: "machine", Monitor: "title"},
+		}},
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		form       url.Values
+		bearer     string
+		wantStatus int
+	}{
+		{"wrong token", http.MethodGet, "/webhook/poweradmin?token=wrong&machine=FXH1&title=Ping&status=Alert", nil, "", http.StatusUnauthorized},
+		{"missing monitor", http.MethodGet, "/webhook/poweradmin?token=secret&machine=FXH1&status=Alert", nil, "", http.StatusBadRequest},
+		{"unknown monitor", http.MethodGet, "/webhook/poweradmin?token=secret&machine=FXH9&title=Ping&status=Alert", nil, "", http.StatusAccepted},
+		{"wrong method", http.MethodPut, "/webhook/poweradmin?token=secret", nil, "", http.StatusMethodNotAllowed},
+		{"query", http.MethodGet, "/webhook/poweradmin?token=secret&machine=FXH1&title=Ping&status=Alert&err_text=Timeout", nil, "", http.StatusOK},
+		{"form", http.MethodPost, "/webhook/poweradmin", url.Values{"monitor_id": {"8938"}, "status": {"Alert"}}, "secret", http.StatusOK},
+	}
+	receivedBefore := readCounter(alertActionsReceivedTotal, "alert")
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.target, strings.NewReader(test.form.Encode()))
+		if test.form != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		if test.bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+test.bearer)
+		}
+		recorder := httptest.NewRecorder()
+		reloader.WebhookHandler(recorder, req)
+		if recorder.Code != test.wantStatus {
+			t.Errorf("Wrong status for %s: got %v, want %v", test.name, recorder.Code, test.wantStatus)
+		}
+	}
+
+	if _, exists := overlay.statuses[""][overlayKey(MonitoredValue{ServerName: "FXH9", MonitorTitle: "Ping"})]; exists {
+		t.Errorf("The status of a monitor not collected should not be kept")
+	}
+	for _, key := range []string{"8937", "8938"} {
+		state := tracker.states[""][key]
+		if state.Status != "alert" || state.Changes != 1 {
+			t.Errorf("The status of monitor %s should be updated: got %+v", key, state)
+		}
+	}
+	if got := readCounter(alertActionsReceivedTotal, "alert"); got != receivedBefore+3 {
+		t.Errorf("Wrong number of received alert actions: got %v, want %v", got, receivedBefore+3)
+	}
+}
+
+func TestReloader_WebhookHandler_Disabled(t *testing.T) {
+	reloader := &Reloader{tracker: NewStatusTracker()}
+	recorder := httptest.NewRecorder()
+	reloader.WebhookHandler(recorder, httptest.NewRequest(http.MethodGet, "/webhook/poweradmin?token=", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Wrong status without token: got %v, want %v", recorder.Code, http.StatusNotFound)
+	}
+}
+
+func TestReloader_WebhookHandler_NextScrape(t *testing.T) {
+	lastRun := time.Now().Add(-time.Minute)
+	api := &MockPAExternalAPI{}
+	api.On("GetResources", mock.Anything).Return(&MonitoredValues{Values: []MonitoredValue{
+		{MonitorID: "8937", MonitorTitle: "Ping", MonitorStatus: "OK", MonitorValue: "OK", ServerName: "FXH1", GroupPath: "Dev", MonitorLastRun: lastRun},
+	}}, nil)
+	config := Config{
+		StatusTracking: StatusTrackingConfig{Enabled: true},
+		StatusMapping:  StatusConfig{Default: 1},
+		Webhook:        WebhookConfig{Token: "secret"},
+	}
+	reloader := &Reloader{tracker: NewStatusTracker(), overlay: NewStatusOverlay(), config: config}
+	collector := NewCollector(api, config)
+	collector.Tracker = reloader.tracker
+	collector.Overlay = reloader.overlay
+	scrape := func() {
+		ch := make(chan prometheus.Metric)
+		go func() {
+			collector.Collect(ch)
+			close(ch)
+		}()
+		for range ch {
+		}
+	}
+
+	scrape()
+	recorder := httptest.NewRecorder()
+	reloader.WebhookHandler(recorder, httptest.NewRequest(http.MethodGet, "/webhook/poweradmin?token=secret&monitor_id=8937&status=Alert", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Wrong status: got %v, want %v", recorder.Code, http.StatusOK)
+	}
+	// PowerAdmin still reports the last run before the alert action
+	scrape()
+
+	state := reloader.tracker.states[""]["8937"]
+	if state.Status != "alert" || state.Changes != 1 {
+		t.Errorf("The received status should be kept until the monitor runs again: got %+v", state)
+	}
+}